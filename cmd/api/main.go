@@ -7,6 +7,7 @@ import (
 	"gateway/internal/config"
 	"gateway/internal/handlers"
 	"gateway/internal/middleware"
+	"gateway/internal/models"
 	"gateway/internal/services"
 	"log"
 	"net/http"
@@ -19,6 +20,7 @@ import (
 	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
@@ -43,21 +45,39 @@ func main() {
 	routeService := services.NewRouteService(db)
 	apiKeyService := services.NewAPIKeyService(db)
 	cacheRuleService := services.NewCacheRuleService(db)
+	orgService := services.NewOrgService(db)
+	auditService := services.NewAuditService(db, cfg.AuditHMACKey)
 	rateLimiter := services.NewRateLimiter(redisClient)
-	cacheService := services.NewCacheService(redisClient)
+	localCache := services.NewLocalCache(cfg.CacheLocalMaxBytes, cfg.CacheLocalMaxEntries, cfg.CacheLocalTTL)
+	cacheService := services.NewCacheService(redisClient, services.CacheOptions{
+		MaxVariants:      cfg.CacheMaxVariants,
+		LockTimeout:      cfg.CacheLockTimeout,
+		Local:            localCache,
+		MaxEntryBytes:    cfg.CacheMaxEntryBytes,
+		Compression:      cfg.CacheCompression,
+		CompressMinBytes: cfg.CacheCompressMinBytes,
+	})
+	nonceCache := services.NewNonceCache(redisClient)
 	proxyService := services.NewProxyService()
 	analyticsService := analytics.NewAnalytics(db)
 
-	routeHandler := handlers.NewRouteHandler(routeService)
-	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
-	cacheRuleHandler := handlers.NewCacheRuleHandler(cacheRuleService, cacheService)
+	routeHandler := handlers.NewRouteHandler(routeService, auditService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService, auditService)
 	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService)
+	orgHandler := handlers.NewOrgHandler(orgService, auditService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	consumerHandler := handlers.NewConsumerHandler(rateLimiter, analyticsService, cacheService)
 	proxyHandler := handlers.NewProxyHandler(routeService, proxyService, cacheService, cacheRuleService, analyticsService)
+	cacheRuleHandler := handlers.NewCacheRuleHandler(cacheRuleService, routeService, cacheService, proxyHandler, auditService)
 
 	analyticsCtx, cancelAnalytics := context.WithCancel(ctx)
 	defer cancelAnalytics()
 	go analyticsService.Start(analyticsCtx)
 
+	cacheCtx, cancelCache := context.WithCancel(ctx)
+	defer cancelCache()
+	go cacheService.Start(cacheCtx)
+
 	r := chi.NewRouter()
 
 	r.Use(chimiddleware.RequestID)
@@ -66,13 +86,22 @@ func main() {
 	r.Use(chimiddleware.Recoverer)
 	r.Use(chimiddleware.Timeout(60 * time.Second))
 
+	keySource := middleware.NewKeySource(cfg.APIKeyLocation, cfg.APIKeyName)
+	allowedHeaders := []string{
+		"Accept", "Authorization", "Content-Type", "X-CSRF-Token", middleware.OrgIDHeader,
+		middleware.HMACKeyIDHeader, middleware.HMACTimestampHeader, middleware.HMACNonceHeader, middleware.HMACSignatureHeader,
+	}
+	if keySource.Location == models.KeyLocationHeader {
+		allowedHeaders = append(allowedHeaders, keySource.Name)
+	}
+
 	// Log allowed origins for debugging (remove in production if needed)
 	log.Printf("CORS Allowed Origins: %v", cfg.AllowOrigins)
 	
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   cfg.AllowOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   allowedHeaders,
 		ExposedHeaders:   []string{"Link", "X-RateLimit-Limit", "X-Cache"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -90,35 +119,90 @@ func main() {
 		w.Write([]byte(`{"message":"Apex Gateway API","version":"1.0","endpoints":{"health":"/health","admin":"/admin/*","docs":"https://github.com/your-repo"}}`))
 	})
 
-	clerkAuth := middleware.NewClerkAuth(cfg.ClerkJWKSURL)
+	adminAuth, err := newAdminAuthenticator(cfg, redisClient, rateLimiter)
+	if err != nil {
+		log.Fatalf("Failed to configure admin authentication: %v", err)
+	}
+
+	// Bodies the gateway reads itself are held to the global default; proxied
+	// bodies follow each route's limit below.
+	limitBody := middleware.LimitRequestBody(cfg.MaxRequestBodyBytes)
+
+	// Login and logout must be reachable without a session.
+	if localAuth, ok := adminAuth.(*middleware.LocalAuth); ok {
+		r.With(limitBody).Post("/admin/login", localAuth.Login)
+		r.With(limitBody).Post("/admin/logout", localAuth.Logout)
+	}
 
 	r.Route("/admin", func(r chi.Router) {
-		r.Use(clerkAuth.Middleware())
+		r.Use(limitBody)
+		r.Use(adminAuth.Middleware())
+		r.Use(middleware.OrgContext(orgService))
+
+		r.Get("/orgs", orgHandler.List)
+		r.Post("/orgs", orgHandler.Create)
+		r.Delete("/orgs/{orgID}", orgHandler.Delete)
+		r.Get("/orgs/{orgID}/members", orgHandler.ListMembers)
+		r.Post("/orgs/{orgID}/members", orgHandler.AddMember)
+		r.Put("/orgs/{orgID}/members/{userID}", orgHandler.UpdateMember)
+		r.Delete("/orgs/{orgID}/members/{userID}", orgHandler.RemoveMember)
+
 		r.Post("/routes", routeHandler.Create)
 		r.Get("/routes", routeHandler.List)
 		r.Get("/routes/{id}", routeHandler.Get)
 		r.Put("/routes/{id}", routeHandler.Update)
 		r.Delete("/routes/{id}", routeHandler.Delete)
+		r.Delete("/routes/{id}/cache", cacheRuleHandler.InvalidateRoute)
+		r.Get("/routes/{id}/cache/stats", cacheRuleHandler.RouteStats)
+		r.Get("/routes/{id}/cache/entries", cacheRuleHandler.ListEntries)
+		r.Get("/routes/{id}/cache/entries/{key}", cacheRuleHandler.GetEntry)
+		r.Post("/routes/{id}/cache/warm", cacheRuleHandler.Warm)
 
 		r.Post("/api-keys", apiKeyHandler.Create)
 		r.Get("/api-keys", apiKeyHandler.List)
+		r.Put("/api-keys/{id}/scopes", apiKeyHandler.UpdateScopes)
+		r.Get("/api-keys/{id}/restrictions", apiKeyHandler.GetRestrictions)
+		r.Put("/api-keys/{id}/restrictions", apiKeyHandler.UpdateRestrictions)
+		r.Post("/api-keys/{id}/signing-secret", apiKeyHandler.GenerateSigningSecret)
 		r.Post("/api-keys/{id}/revoke", apiKeyHandler.Revoke)
 		r.Delete("/api-keys/{id}", apiKeyHandler.Delete)
+		r.Get("/api-keys/{id}/usage", analyticsHandler.GetAPIKeyUsage)
 
 		r.Post("/cache-rules", cacheRuleHandler.Create)
 		r.Get("/cache-rules", cacheRuleHandler.List)
 		r.Put("/cache-rules/{id}", cacheRuleHandler.Update)
 		r.Delete("/cache-rules/{id}", cacheRuleHandler.Delete)
 		r.Post("/cache/invalidate", cacheRuleHandler.Invalidate)
+		r.Post("/cache/purge", cacheRuleHandler.Purge)
+		r.Get("/cache/stats", cacheRuleHandler.Stats)
 
 		r.Get("/analytics/metrics", analyticsHandler.GetMetrics)
 		r.Get("/analytics/stream", analyticsHandler.StreamMetrics)
+
+		r.Get("/audit", auditHandler.List)
+		r.Get("/audit/verify", auditHandler.Verify)
+	})
+
+	// Reserved gateway paths for API key holders. Route creation rejects
+	// paths under this prefix, so these are never proxied.
+	r.Route(services.ReservedPathPrefix, func(r chi.Router) {
+		r.Use(limitBody)
+		r.Use(middleware.APIKeyAuth(apiKeyService, analyticsService, keySource))
+		r.Get("/me", consumerHandler.Me)
+		r.Post("/purge", consumerHandler.Purge)
 	})
 
 	// Proxy routes - catch-all for API proxying (requires API key)
 	// This must be last to not override specific routes
 	r.Group(func(r chi.Router) {
-		r.Use(middleware.APIKeyAuth(apiKeyService))
+		r.Use(middleware.ResolveRoute(routeService))
+		r.Use(middleware.LimitRequestBody(cfg.MaxRequestBodyBytes))
+		r.Use(middleware.Compress())
+		r.Use(middleware.RouteAuth(map[string]func(http.Handler) http.Handler{
+			models.AuthModeAPIKey: middleware.APIKeyAuth(apiKeyService, analyticsService, keySource),
+			models.AuthModeHMAC:   middleware.HMACAuth(apiKeyService, nonceCache, analyticsService, cfg.HMACClockSkew),
+			models.AuthModeJWT:    middleware.JWTAuth(middleware.NewJWKSCache()),
+		}))
 		r.Use(middleware.RateLimiting(rateLimiter))
 		r.HandleFunc("/*", proxyHandler.Forward)
 	})
@@ -153,3 +237,32 @@ func main() {
 
 	fmt.Println("Server exited")
 }
+
+func newAdminAuthenticator(cfg *config.Config, redisClient *redis.Client, rateLimiter *services.RateLimiter) (middleware.AdminAuthenticator, error) {
+	log.Printf("Admin authentication provider: %s", cfg.AdminAuthProvider)
+
+	switch cfg.AdminAuthProvider {
+	case config.AdminAuthClerk:
+		return middleware.NewClerkAuth(middleware.ClerkAuthConfig{
+			JWKSURL:            cfg.ClerkJWKSURL,
+			Issuer:             cfg.ClerkIssuer,
+			Audience:           cfg.ClerkAudience,
+			AuthorizedParties:  cfg.ClerkAuthorizedParties,
+			Leeway:             cfg.ClerkLeeway,
+			MinRefreshInterval: cfg.ClerkJWKSMinRefreshInterval,
+		})
+	case config.AdminAuthOIDC:
+		return middleware.NewOIDCAuth(middleware.OIDCAuthConfig{
+			IssuerURL: cfg.OIDCIssuerURL,
+			ClientID:  cfg.OIDCClientID,
+			UserClaim: cfg.OIDCUserClaim,
+			Leeway:    cfg.OIDCLeeway,
+		})
+	case config.AdminAuthStatic:
+		return middleware.NewStaticTokenAuth(cfg.AdminTokens)
+	case config.AdminAuthLocal:
+		sessions := services.NewAdminSessionStore(redisClient, cfg.AdminSessionTTL)
+		return middleware.NewLocalAuth(cfg.AdminUsers, sessions, rateLimiter, cfg.AdminCookieSecure)
+	}
+	return nil, fmt.Errorf("unknown ADMIN_AUTH_PROVIDER %q", cfg.AdminAuthProvider)
+}
@@ -2,14 +2,19 @@ package analytics
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gateway/internal/models"
+	"gateway/internal/services"
+	"sort"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var ErrAPIKeyNotFound = errors.New("API key not found")
+
 type Analytics struct {
 	db      *pgxpool.Pool
 	eventCh chan *models.AnalyticsEvent
@@ -67,17 +72,39 @@ func (a *Analytics) flushEvents(ctx context.Context, events []*models.AnalyticsE
 	for _, event := range events {
 		batch.Queue(
 			`INSERT INTO analytics_events 
-			(timestamp, route_id, api_key_id, user_id, status_code, latency_ms, cache_hit, ip_address)
-			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-			event.Timestamp, event.RouteID, event.APIKeyID, event.UserID,
-			event.StatusCode, event.LatencyMs, event.CacheHit, event.IPAddress,
+			(timestamp, route_id, api_key_id, org_id, user_id, status_code, latency_ms, cache_hit, cache_stale, cache_tier, ip_address, reason)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''), $11, NULLIF($12, ''))`,
+			event.Timestamp, event.RouteID, event.APIKeyID, event.OrgID, event.UserID,
+			event.StatusCode, event.LatencyMs, event.CacheHit, event.CacheStale, event.CacheTier, event.IPAddress, event.Reason,
+		)
+	}
+
+	usage := keyUsageFromEvents(events)
+	// Update keys in a fixed order so concurrent flushes from several
+	// gateway instances cannot deadlock on each other's rows.
+	keyIDs := make([]int64, 0, len(usage))
+	for keyID := range usage {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Slice(keyIDs, func(i, j int) bool { return keyIDs[i] < keyIDs[j] })
+	for _, keyID := range keyIDs {
+		u := usage[keyID]
+		// SET expressions see the row before the update, so last_used_ip only
+		// moves forward together with last_used_at.
+		batch.Queue(
+			`UPDATE api_keys SET
+				request_count = request_count + $1,
+				last_used_at = GREATEST(last_used_at, $2),
+				last_used_ip = CASE WHEN last_used_at IS NULL OR last_used_at <= $2 THEN $3 ELSE last_used_ip END
+			 WHERE id = $4`,
+			u.count, u.lastUsedAt, u.lastUsedIP, keyID,
 		)
 	}
 
 	br := a.db.SendBatch(ctx, batch)
 	defer br.Close()
 
-	for range events {
+	for i := 0; i < len(events)+len(usage); i++ {
 		if _, err := br.Exec(); err != nil {
 			// Optional: log error
 			return
@@ -85,20 +112,55 @@ func (a *Analytics) flushEvents(ctx context.Context, events []*models.AnalyticsE
 	}
 }
 
-func (a *Analytics) GetMetrics(ctx context.Context, userID string, startTime, endTime time.Time) (*models.AnalyticsMetrics, error) {
+type keyUsage struct {
+	count      int64
+	lastUsedAt time.Time
+	lastUsedIP string
+}
+
+// keyUsageFromEvents totals a batch of events per API key, so that usage
+// counters cost one update per key per flush rather than one per request.
+func keyUsageFromEvents(events []*models.AnalyticsEvent) map[int64]*keyUsage {
+	usage := make(map[int64]*keyUsage)
+	for _, event := range events {
+		if event.APIKeyID == nil {
+			continue
+		}
+		u, ok := usage[*event.APIKeyID]
+		if !ok {
+			u = &keyUsage{}
+			usage[*event.APIKeyID] = u
+		}
+		u.count++
+		if !event.Timestamp.Before(u.lastUsedAt) {
+			u.lastUsedAt = event.Timestamp
+			u.lastUsedIP = event.IPAddress
+		}
+	}
+	return usage
+}
+
+func (a *Analytics) GetMetrics(ctx context.Context, actor *models.Membership, startTime, endTime time.Time) (*models.AnalyticsMetrics, error) {
+	if err := services.Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
 	metrics := &models.AnalyticsMetrics{}
 
-	var totalRequests, cacheHits, errors int64
+	var totalRequests, cacheHits, localHits, redisHits, errors int64
 	err := a.db.QueryRow(
 		ctx,
 		`SELECT 
 			COUNT(*) as total,
 			COUNT(*) FILTER (WHERE cache_hit = true) as cache_hits,
+			COUNT(*) FILTER (WHERE cache_stale = true) as stale_serves,
+			COUNT(*) FILTER (WHERE cache_tier = 'local') as local_hits,
+			COUNT(*) FILTER (WHERE cache_tier = 'redis') as redis_hits,
 			COUNT(*) FILTER (WHERE status_code >= 400) as errors
 		 FROM analytics_events 
-		 WHERE timestamp >= $1 AND timestamp <= $2 AND user_id = $3`,
-		startTime, endTime, userID,
-	).Scan(&totalRequests, &cacheHits, &errors)
+		 WHERE timestamp >= $1 AND timestamp <= $2 AND org_id = $3`,
+		startTime, endTime, actor.OrgID,
+	).Scan(&totalRequests, &cacheHits, &metrics.StaleServes, &localHits, &redisHits, &errors)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get basic metrics: %w", err)
@@ -108,6 +170,8 @@ func (a *Analytics) GetMetrics(ctx context.Context, userID string, startTime, en
 	if totalRequests > 0 {
 		metrics.ErrorRate = float64(errors) / float64(totalRequests)
 		metrics.CacheHitRatio = float64(cacheHits) / float64(totalRequests)
+		metrics.LocalCacheHitRatio = float64(localHits) / float64(totalRequests)
+		metrics.RedisCacheHitRatio = float64(redisHits) / float64(totalRequests)
 	}
 
 	var p50, p95, p99 *int64
@@ -118,8 +182,8 @@ func (a *Analytics) GetMetrics(ctx context.Context, userID string, startTime, en
 			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY latency_ms) as p95,
 			PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY latency_ms) as p99
 		 FROM analytics_events 
-		 WHERE timestamp >= $1 AND timestamp <= $2 AND user_id = $3`,
-		startTime, endTime, userID,
+		 WHERE timestamp >= $1 AND timestamp <= $2 AND org_id = $3`,
+		startTime, endTime, actor.OrgID,
 	).Scan(&p50, &p95, &p99)
 
 	if err == nil && p50 != nil {
@@ -134,11 +198,11 @@ func (a *Analytics) GetMetrics(ctx context.Context, userID string, startTime, en
 			DATE_TRUNC('minute', timestamp) as minute,
 			COUNT(*) as count
 		 FROM analytics_events 
-		 WHERE timestamp >= $1 AND timestamp <= $2 AND user_id = $3
+		 WHERE timestamp >= $1 AND timestamp <= $2 AND org_id = $3
 		 GROUP BY minute
 		 ORDER BY minute DESC
 		 LIMIT 60`,
-		startTime, endTime, userID,
+		startTime, endTime, actor.OrgID,
 	)
 	if err == nil {
 		defer rows.Close()
@@ -159,11 +223,11 @@ func (a *Analytics) GetMetrics(ctx context.Context, userID string, startTime, en
 			COUNT(*) FILTER (WHERE ae.status_code >= 400)::float / COUNT(*)::float as error_rate
 		 FROM analytics_events ae
 		 LEFT JOIN routes r ON ae.route_id = r.id
-		 WHERE ae.timestamp >= $1 AND ae.timestamp <= $2 AND ae.user_id = $3 AND r.path IS NOT NULL AND r.user_id = $3
+		 WHERE ae.timestamp >= $1 AND ae.timestamp <= $2 AND ae.org_id = $3 AND r.path IS NOT NULL AND r.org_id = $3
 		 GROUP BY r.path
 		 ORDER BY request_count DESC
 		 LIMIT 10`,
-		startTime, endTime, userID,
+		startTime, endTime, actor.OrgID,
 	)
 	if err == nil {
 		defer endpointRows.Close()
@@ -178,8 +242,90 @@ func (a *Analytics) GetMetrics(ctx context.Context, userID string, startTime, en
 	return metrics, nil
 }
 
-func (a *Analytics) GetRealtimeMetrics(ctx context.Context, userID string) (*models.AnalyticsMetrics, error) {
+func (a *Analytics) GetRealtimeMetrics(ctx context.Context, actor *models.Membership) (*models.AnalyticsMetrics, error) {
 	now := time.Now()
 	startTime := now.Add(-5 * time.Minute)
-	return a.GetMetrics(ctx, userID, startTime, now)
+	return a.GetMetrics(ctx, actor, startTime, now)
+}
+
+// GetAPIKeyUsage returns usage for one of the actor's organization's keys,
+// bucketed by interval (minute, hour or day).
+func (a *Analytics) GetAPIKeyUsage(ctx context.Context, actor *models.Membership, keyID int64, startTime, endTime time.Time, interval string) (*models.APIKeyUsage, error) {
+	if err := services.Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	usage := &models.APIKeyUsage{APIKeyID: keyID, Interval: interval, Series: []models.UsageBucket{}}
+	err := a.db.QueryRow(
+		ctx,
+		`SELECT request_count, last_used_at, last_used_ip FROM api_keys WHERE id = $1 AND org_id = $2`,
+		keyID, actor.OrgID,
+	).Scan(&usage.RequestCount, &usage.LastUsedAt, &usage.LastUsedIP)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key usage: %w", err)
+	}
+
+	rows, err := a.db.Query(
+		ctx,
+		`SELECT
+			DATE_TRUNC($1, timestamp) as bucket,
+			COUNT(*) as requests,
+			COUNT(*) FILTER (WHERE status_code >= 400) as errors,
+			COUNT(*) FILTER (WHERE reason IS NOT NULL) as rejected
+		 FROM analytics_events
+		 WHERE api_key_id = $2 AND timestamp >= $3 AND timestamp <= $4
+		 GROUP BY bucket
+		 ORDER BY bucket`,
+		interval, keyID, startTime, endTime,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key usage: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var bucket models.UsageBucket
+		if err := rows.Scan(&bucket.Timestamp, &bucket.Requests, &bucket.Errors, &bucket.Rejected); err != nil {
+			return nil, fmt.Errorf("failed to scan API key usage: %w", err)
+		}
+		usage.Series = append(usage.Series, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get API key usage: %w", err)
+	}
+
+	return usage, nil
+}
+
+// GetAPIKeySummaries totals a key's requests over the last hour and the last
+// 24 hours. It is meant for the key's holder, so no membership is checked.
+func (a *Analytics) GetAPIKeySummaries(ctx context.Context, keyID int64) (lastHour, last24Hours *models.UsageSummary, err error) {
+	now := time.Now()
+	lastHour, last24Hours = &models.UsageSummary{}, &models.UsageSummary{}
+	err = a.db.QueryRow(
+		ctx,
+		`SELECT
+			COUNT(*) FILTER (WHERE timestamp >= $2),
+			COUNT(*) FILTER (WHERE timestamp >= $2 AND status_code >= 400),
+			COUNT(*) FILTER (WHERE timestamp >= $2 AND reason IS NOT NULL),
+			COUNT(*),
+			COUNT(*) FILTER (WHERE status_code >= 400),
+			COUNT(*) FILTER (WHERE reason IS NOT NULL)
+		 FROM analytics_events
+		 WHERE api_key_id = $1 AND timestamp >= $3`,
+		keyID, now.Add(-time.Hour), now.Add(-24*time.Hour),
+	).Scan(&lastHour.Requests, &lastHour.Errors, &lastHour.Rejected, &last24Hours.Requests, &last24Hours.Errors, &last24Hours.Rejected)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get API key usage: %w", err)
+	}
+
+	for _, summary := range []*models.UsageSummary{lastHour, last24Hours} {
+		if summary.Requests > 0 {
+			summary.ErrorRate = float64(summary.Errors) / float64(summary.Requests)
+		}
+	}
+	return lastHour, last24Hours, nil
 }
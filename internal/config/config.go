@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -11,20 +12,80 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// Admin authentication providers, selected with ADMIN_AUTH_PROVIDER.
+const (
+	AdminAuthClerk  = "clerk"
+	AdminAuthOIDC   = "oidc"
+	AdminAuthStatic = "static"
+	AdminAuthLocal  = "local"
+)
+
 type Config struct {
 	Port         string
 	DatabaseURL  string
 	RedisURL     string
 	RedisToken   string
 	AllowOrigins []string
-	ClerkJWKSURL string
+	// AdminAuthProvider picks how /admin requests are authenticated:
+	// clerk, oidc, static or local.
+	AdminAuthProvider string
+	ClerkJWKSURL      string
+	// Optional Clerk session token checks. The issuer defaults to the origin
+	// of ClerkJWKSURL.
+	ClerkIssuer                 string
+	ClerkAudience               string
+	ClerkAuthorizedParties      []string
+	ClerkLeeway                 time.Duration
+	ClerkJWKSMinRefreshInterval time.Duration
+	OIDCIssuerURL               string
+	OIDCClientID                string
+	OIDCUserClaim               string
+	OIDCLeeway                  time.Duration
+	// Static admin bearer tokens and local admin bcrypt password hashes,
+	// both keyed by user ID.
+	AdminTokens       map[string]string
+	AdminUsers        map[string]string
+	AdminSessionTTL   time.Duration
+	AdminCookieSecure bool
+	// Default API key location for routes that do not set their own:
+	// bearer, header, query or cookie.
+	APIKeyLocation string
+	APIKeyName     string
+	// Maximum allowed difference between a signed request's timestamp and
+	// the gateway clock.
+	HMACClockSkew time.Duration
+	// Optional key for HMAC-chaining audit log entries. Without it the chain
+	// uses plain SHA-256.
+	AuditHMACKey string
+	// Most representations kept per cached resource for responses that
+	// vary on request headers.
+	CacheMaxVariants int
+	// How long a cache miss may hold a Redis lock so that other gateway
+	// instances wait for its response instead of fetching the same key.
+	// Zero disables the lock; misses are still coalesced per instance.
+	CacheLockTimeout time.Duration
+	// In-process cache tier in front of Redis, disabled when
+	// CacheLocalMaxBytes is zero.
+	CacheLocalMaxBytes   int64
+	CacheLocalMaxEntries int
+	CacheLocalTTL        time.Duration
+	// Largest cached body for rules without their own limit; zero disables
+	// the limit.
+	CacheMaxEntryBytes int64
+	// Content coding (gzip, zstd or none) for cached bodies of at least
+	// CacheCompressMinBytes.
+	CacheCompression      string
+	CacheCompressMinBytes int
+	// Largest request body accepted by the admin API, the reserved gateway
+	// paths and routes without max_body_bytes; zero disables the limit.
+	MaxRequestBodyBytes int64
 }
 
 func Load() *Config {
 	// Support multiple origins - comma-separated list or single origin
 	frontendURL := getEnv("FRONTEND_URL", "http://localhost:3000")
 	allowedOriginsEnv := getEnv("ALLOWED_ORIGINS", "")
-	
+
 	var allowedOrigins []string
 	if allowedOriginsEnv != "" {
 		// Parse comma-separated origins
@@ -42,7 +103,7 @@ func Load() *Config {
 		frontendURL = strings.TrimSuffix(frontendURL, "/")
 		allowedOrigins = []string{frontendURL}
 	}
-	
+
 	// Always include localhost for local development (if not in production)
 	hasLocalhost := false
 	for _, origin := range allowedOrigins {
@@ -57,12 +118,39 @@ func Load() *Config {
 	}
 
 	return &Config{
-		Port:         getEnv("PORT", "8080"),
-		DatabaseURL:  getEnv("DATABASE_URL", ""),
-		RedisURL:     getEnv("REDIS_URL", ""),
-		RedisToken:   getEnv("REDIS_TOKEN", ""),
-		AllowOrigins: allowedOrigins,
-		ClerkJWKSURL: getEnv("CLERK_JWKS_URL", ""),
+		Port:                        getEnv("PORT", "8080"),
+		DatabaseURL:                 getEnv("DATABASE_URL", ""),
+		RedisURL:                    getEnv("REDIS_URL", ""),
+		RedisToken:                  getEnv("REDIS_TOKEN", ""),
+		AllowOrigins:                allowedOrigins,
+		AdminAuthProvider:           getEnv("ADMIN_AUTH_PROVIDER", AdminAuthClerk),
+		ClerkJWKSURL:                getEnv("CLERK_JWKS_URL", ""),
+		ClerkIssuer:                 getEnv("CLERK_ISSUER", ""),
+		ClerkAudience:               getEnv("CLERK_AUDIENCE", ""),
+		ClerkAuthorizedParties:      getEnvList("CLERK_AUTHORIZED_PARTIES"),
+		ClerkLeeway:                 time.Duration(getEnvInt("CLERK_LEEWAY_SECONDS", 5)) * time.Second,
+		ClerkJWKSMinRefreshInterval: time.Duration(getEnvInt("CLERK_JWKS_MIN_REFRESH_SECONDS", 300)) * time.Second,
+		OIDCIssuerURL:               getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:                getEnv("OIDC_CLIENT_ID", ""),
+		OIDCUserClaim:               getEnv("OIDC_USER_CLAIM", "sub"),
+		OIDCLeeway:                  time.Duration(getEnvInt("OIDC_LEEWAY_SECONDS", 5)) * time.Second,
+		AdminTokens:                 getEnvPairs("ADMIN_TOKENS"),
+		AdminUsers:                  getEnvPairs("ADMIN_USERS"),
+		AdminSessionTTL:             time.Duration(getEnvInt("ADMIN_SESSION_TTL_SECONDS", 43200)) * time.Second,
+		AdminCookieSecure:           getEnv("ADMIN_COOKIE_SECURE", "true") != "false",
+		APIKeyLocation:              getEnv("API_KEY_LOCATION", "bearer"),
+		APIKeyName:                  getEnv("API_KEY_NAME", ""),
+		HMACClockSkew:               time.Duration(getEnvInt("HMAC_CLOCK_SKEW_SECONDS", 300)) * time.Second,
+		AuditHMACKey:                getEnv("AUDIT_HMAC_KEY", ""),
+		CacheMaxVariants:            getEnvInt("CACHE_MAX_VARIANTS", 8),
+		CacheLockTimeout:            time.Duration(getEnvInt("CACHE_LOCK_TIMEOUT_MS", 0)) * time.Millisecond,
+		CacheLocalMaxBytes:          int64(getEnvInt("CACHE_LOCAL_MAX_MB", 0)) << 20,
+		CacheLocalMaxEntries:        getEnvInt("CACHE_LOCAL_MAX_ENTRIES", 10000),
+		CacheLocalTTL:               time.Duration(getEnvInt("CACHE_LOCAL_TTL_SECONDS", 5)) * time.Second,
+		CacheMaxEntryBytes:          int64(getEnvInt("CACHE_MAX_ENTRY_BYTES", 1<<20)),
+		CacheCompression:            getEnv("CACHE_COMPRESSION", "gzip"),
+		CacheCompressMinBytes:       getEnvInt("CACHE_COMPRESS_MIN_BYTES", 1024),
+		MaxRequestBodyBytes:         int64(getEnvInt("MAX_REQUEST_BODY_BYTES", 10<<20)),
 	}
 }
 
@@ -73,6 +161,36 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
+
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvPairs parses a comma-separated list of name:value pairs. Only the
+// first colon separates the two, so values may contain colons.
+func getEnvPairs(key string) map[string]string {
+	pairs := make(map[string]string)
+	for _, item := range getEnvList(key) {
+		name, value, ok := strings.Cut(item, ":")
+		if ok && name != "" && value != "" {
+			pairs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	return pairs
+}
+
 func NewPostgresPool(ctx context.Context, databaseURL string) (*pgxpool.Pool, error) {
 	config, err := pgxpool.ParseConfig(databaseURL)
 	if err != nil {
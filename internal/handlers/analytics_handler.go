@@ -2,11 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"gateway/internal/analytics"
 	"gateway/internal/middleware"
+	"gateway/internal/models"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 type AnalyticsHandler struct {
@@ -18,46 +23,86 @@ func NewAnalyticsHandler(analytics *analytics.Analytics) *AnalyticsHandler {
 }
 
 func (h *AnalyticsHandler) GetMetrics(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	startStr := r.URL.Query().Get("start")
-	endStr := r.URL.Query().Get("end")
+	startTime, endTime, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
 
-	var startTime, endTime time.Time
-	var err error
+	metrics, err := h.analytics.GetMetrics(r.Context(), member, startTime, endTime)
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get metrics"}`, http.StatusInternalServerError)
+		return
+	}
 
-	if startStr != "" {
-		startTime, err = time.Parse(time.RFC3339, startStr)
-		if err != nil {
-			http.Error(w, `{"error":"invalid start time format"}`, http.StatusBadRequest)
-			return
-		}
-	} else {
-		startTime = time.Now().Add(-24 * time.Hour)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metrics)
+}
+
+// Bucket sizes accepted by GetAPIKeyUsage.
+var usageIntervals = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+const maxUsageBuckets = 1440
+
+func (h *AnalyticsHandler) GetAPIKeyUsage(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
 	}
 
-	if endStr != "" {
-		endTime, err = time.Parse(time.RFC3339, endStr)
-		if err != nil {
-			http.Error(w, `{"error":"invalid end time format"}`, http.StatusBadRequest)
-			return
-		}
-	} else {
-		endTime = time.Now()
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid API key ID"}`, http.StatusBadRequest)
+		return
 	}
 
-	metrics, err := h.analytics.GetMetrics(r.Context(), userID, startTime, endTime)
+	startTime, endTime, ok := parseTimeRange(w, r)
+	if !ok {
+		return
+	}
+
+	interval := r.URL.Query().Get("interval")
+	if interval == "" {
+		interval = "hour"
+	}
+	bucket, ok := usageIntervals[interval]
+	if !ok {
+		http.Error(w, `{"error":"interval must be minute, hour or day"}`, http.StatusBadRequest)
+		return
+	}
+	if endTime.Sub(startTime)/bucket > maxUsageBuckets {
+		http.Error(w, `{"error":"time range too large for interval"}`, http.StatusBadRequest)
+		return
+	}
+
+	usage, err := h.analytics.GetAPIKeyUsage(r.Context(), member, id, startTime, endTime, interval)
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, analytics.ErrAPIKeyNotFound) {
+		http.Error(w, `{"error":"API key not found"}`, http.StatusNotFound)
+		return
+	}
 	if err != nil {
-		http.Error(w, `{"error":"failed to get metrics"}`, http.StatusInternalServerError)
+		http.Error(w, `{"error":"failed to get API key usage"}`, http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(metrics)
+	json.NewEncoder(w).Encode(usage)
 }
 
 func (h *AnalyticsHandler) StreamMetrics(w http.ResponseWriter, r *http.Request) {
@@ -80,11 +125,11 @@ func (h *AnalyticsHandler) StreamMetrics(w http.ResponseWriter, r *http.Request)
 		case <-r.Context().Done():
 			return
 		case <-ticker.C:
-			userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-			if !ok || userID == "" {
+			member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+			if !ok {
 				continue
 			}
-			metrics, err := h.analytics.GetRealtimeMetrics(r.Context(), userID)
+			metrics, err := h.analytics.GetRealtimeMetrics(r.Context(), member)
 			if err != nil {
 				continue
 			}
@@ -99,3 +144,30 @@ func (h *AnalyticsHandler) StreamMetrics(w http.ResponseWriter, r *http.Request)
 		}
 	}
 }
+
+// parseTimeRange reads the RFC3339 start and end query parameters, defaulting
+// to the last 24 hours, and answers the request itself if either is invalid.
+func parseTimeRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	startTime := time.Now().Add(-24 * time.Hour)
+	endTime := time.Now()
+
+	if startStr := r.URL.Query().Get("start"); startStr != "" {
+		t, err := time.Parse(time.RFC3339, startStr)
+		if err != nil {
+			http.Error(w, `{"error":"invalid start time format"}`, http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		startTime = t
+	}
+
+	if endStr := r.URL.Query().Get("end"); endStr != "" {
+		t, err := time.Parse(time.RFC3339, endStr)
+		if err != nil {
+			http.Error(w, `{"error":"invalid end time format"}`, http.StatusBadRequest)
+			return time.Time{}, time.Time{}, false
+		}
+		endTime = t
+	}
+
+	return startTime, endTime, true
+}
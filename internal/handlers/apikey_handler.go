@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"gateway/internal/middleware"
 	"gateway/internal/models"
 	"gateway/internal/services"
@@ -13,15 +15,16 @@ import (
 
 type APIKeyHandler struct {
 	service *services.APIKeyService
+	audit   *services.AuditService
 }
 
-func NewAPIKeyHandler(service *services.APIKeyService) *APIKeyHandler {
-	return &APIKeyHandler{service: service}
+func NewAPIKeyHandler(service *services.APIKeyService, audit *services.AuditService) *APIKeyHandler {
+	return &APIKeyHandler{service: service, audit: audit}
 }
 
 func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -32,7 +35,10 @@ func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	apiKey, err := h.service.Create(r.Context(), userID, &req)
+	apiKey, err := h.service.Create(r.Context(), member, &req, auditor(h.audit, r, member.UserID, "api_key.create", "api_key"))
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to create API key"}`, http.StatusInternalServerError)
 		return
@@ -44,13 +50,16 @@ func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	keys, err := h.service.List(r.Context(), userID)
+	keys, err := h.service.List(r.Context(), member)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to list API keys"}`, http.StatusInternalServerError)
 		return
@@ -60,9 +69,134 @@ func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(keys)
 }
 
+func (h *APIKeyHandler) UpdateScopes(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid API key ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.UpdateAPIKeyScopesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	apiKey, err := h.service.UpdateScopes(r.Context(), member, id, &req, auditor(h.audit, r, member.UserID, "api_key.update_scopes", "api_key"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to update API key scopes"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(apiKey)
+}
+
+func (h *APIKeyHandler) GetRestrictions(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid API key ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	restrictions, err := h.service.GetRestrictions(r.Context(), member, id)
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"API key not found"}`, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restrictions)
+}
+
+func (h *APIKeyHandler) UpdateRestrictions(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid API key ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	var req models.APIKeyRestrictions
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	restrictions, err := h.service.UpdateRestrictions(r.Context(), member, id, &req, auditor(h.audit, r, member.UserID, "api_key.update_restrictions", "api_key"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidRestriction) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to update API key restrictions"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restrictions)
+}
+
+func (h *APIKeyHandler) GenerateSigningSecret(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	idStr := chi.URLParam(r, "id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid API key ID"}`, http.StatusBadRequest)
+		return
+	}
+
+	secret, err := h.service.GenerateSigningSecret(r.Context(), member, id, auditor(h.audit, r, member.UserID, "api_key.rotate_signing_secret", "api_key"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to generate signing secret"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"key_id": id, "signing_secret": secret})
+}
+
 func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -74,7 +208,10 @@ func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Revoke(r.Context(), userID, id); err != nil {
+	if err := h.service.Revoke(r.Context(), member, id, auditor(h.audit, r, member.UserID, "api_key.revoke", "api_key")); err != nil {
+		if writeForbidden(w, err) {
+			return
+		}
 		http.Error(w, `{"error":"failed to revoke API key"}`, http.StatusInternalServerError)
 		return
 	}
@@ -83,8 +220,8 @@ func (h *APIKeyHandler) Revoke(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *APIKeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -96,7 +233,10 @@ func (h *APIKeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Delete(r.Context(), userID, id); err != nil {
+	if err := h.service.Delete(r.Context(), member, id, auditor(h.audit, r, member.UserID, "api_key.delete", "api_key")); err != nil {
+		if writeForbidden(w, err) {
+			return
+		}
 		http.Error(w, `{"error":"failed to delete API key"}`, http.StatusInternalServerError)
 		return
 	}
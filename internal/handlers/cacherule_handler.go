@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"gateway/internal/middleware"
 	"gateway/internal/models"
 	"gateway/internal/services"
@@ -13,19 +15,32 @@ import (
 
 type CacheRuleHandler struct {
 	service      *services.CacheRuleService
+	routeService *services.RouteService
 	cacheService *services.CacheService
+	proxy        *ProxyHandler
+	audit        *services.AuditService
 }
 
-func NewCacheRuleHandler(service *services.CacheRuleService, cacheService *services.CacheService) *CacheRuleHandler {
+func NewCacheRuleHandler(service *services.CacheRuleService, routeService *services.RouteService, cacheService *services.CacheService, proxy *ProxyHandler, audit *services.AuditService) *CacheRuleHandler {
 	return &CacheRuleHandler{
 		service:      service,
+		routeService: routeService,
 		cacheService: cacheService,
+		proxy:        proxy,
+		audit:        audit,
 	}
 }
 
+// Limits for cache inspection and warm-up requests.
+const (
+	defaultCacheEntryPageSize = 50
+	maxCacheEntryPageSize     = 500
+	maxWarmPaths              = 100
+)
+
 func (h *CacheRuleHandler) Create(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -36,7 +51,14 @@ func (h *CacheRuleHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rule, err := h.service.Create(r.Context(), userID, &req)
+	rule, err := h.service.Create(r.Context(), member, &req, auditor(h.audit, r, member.UserID, "cache_rule.create", "cache_rule"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCacheRule) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to create cache rule"}`, http.StatusInternalServerError)
 		return
@@ -48,13 +70,16 @@ func (h *CacheRuleHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *CacheRuleHandler) List(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	rules, err := h.service.List(r.Context(), userID)
+	rules, err := h.service.List(r.Context(), member)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to list cache rules"}`, http.StatusInternalServerError)
 		return
@@ -65,8 +90,8 @@ func (h *CacheRuleHandler) List(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *CacheRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -78,16 +103,20 @@ func (h *CacheRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		TTLSeconds int  `json:"ttl_seconds"`
-		Enabled    bool `json:"enabled"`
-	}
+	var req models.UpdateCacheRuleRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
 		return
 	}
 
-	rule, err := h.service.Update(r.Context(), userID, id, req.TTLSeconds, req.Enabled)
+	rule, err := h.service.Update(r.Context(), member, id, &req, auditor(h.audit, r, member.UserID, "cache_rule.update", "cache_rule"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidCacheRule) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to update cache rule"}`, http.StatusInternalServerError)
 		return
@@ -98,8 +127,8 @@ func (h *CacheRuleHandler) Update(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *CacheRuleHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -111,7 +140,10 @@ func (h *CacheRuleHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Delete(r.Context(), userID, id); err != nil {
+	if err := h.service.Delete(r.Context(), member, id, auditor(h.audit, r, member.UserID, "cache_rule.delete", "cache_rule")); err != nil {
+		if writeForbidden(w, err) {
+			return
+		}
 		http.Error(w, `{"error":"failed to delete cache rule"}`, http.StatusInternalServerError)
 		return
 	}
@@ -119,24 +151,349 @@ func (h *CacheRuleHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// Invalidate deletes cached responses for the organization's routes: one
+// route when route_id is set, all of them otherwise, optionally limited to a
+// single request path.
 func (h *CacheRuleHandler) Invalidate(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if writeForbidden(w, services.Authorize(member, models.RoleEditor)) {
+		return
+	}
+
 	var req struct {
+		RouteID int64  `json:"route_id"`
+		Path    string `json:"path"`
 		Pattern string `json:"pattern"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
 		return
 	}
+	if req.Pattern != "" {
+		http.Error(w, `{"error":"pattern is no longer supported; use route_id and path"}`, http.StatusBadRequest)
+		return
+	}
+
+	var routes []*models.Route
+	if req.RouteID != 0 {
+		route, err := h.routeService.GetByID(r.Context(), member, req.RouteID)
+		if err != nil {
+			http.Error(w, `{"error":"route not found"}`, http.StatusNotFound)
+			return
+		}
+		routes = []*models.Route{route}
+	} else {
+		var err error
+		if routes, err = h.routeService.List(r.Context(), member); err != nil {
+			http.Error(w, `{"error":"failed to list routes"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.invalidate(w, r, member, routes, req.Path)
+}
+
+// InvalidateRoute deletes cached responses for one route, or for one of its
+// request paths when the path query parameter is set.
+func (h *CacheRuleHandler) InvalidateRoute(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if writeForbidden(w, services.Authorize(member, models.RoleEditor)) {
+		return
+	}
+
+	route, ok := h.ownedRoute(w, r, member)
+	if !ok {
+		return
+	}
+
+	h.invalidate(w, r, member, []*models.Route{route}, r.URL.Query().Get("path"))
+}
+
+// RouteStats reports how many resources one route has cached and how much
+// Redis memory they use.
+func (h *CacheRuleHandler) RouteStats(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	route, ok := h.ownedRoute(w, r, member)
+	if !ok {
+		return
+	}
+
+	stats, err := h.cacheService.RouteStats(r.Context(), []int64{route.ID})
+	if err != nil {
+		http.Error(w, `{"error":"failed to get cache stats"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats[0])
+}
+
+// Stats reports cache usage for each of the organization's routes.
+func (h *CacheRuleHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	routes, err := h.routeService.List(r.Context(), member)
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to list routes"}`, http.StatusInternalServerError)
+		return
+	}
+
+	routeIDs := make([]int64, 0, len(routes))
+	for _, route := range routes {
+		routeIDs = append(routeIDs, route.ID)
+	}
+	stats, err := h.cacheService.RouteStats(r.Context(), routeIDs)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get cache stats"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// ListEntries lists a route's cached resources with their size, age and
+// remaining lifetime, optionally for one request path.
+func (h *CacheRuleHandler) ListEntries(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	route, ok := h.ownedRoute(w, r, member)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	var cursor uint64
+	if value := query.Get("cursor"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+		cursor = n
+	}
+	limit := defaultCacheEntryPageSize
+	if value := query.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"invalid limit"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if limit > maxCacheEntryPageSize {
+		limit = maxCacheEntryPageSize
+	}
+
+	entries, next, err := h.cacheService.ListEntries(r.Context(), route.ID, query.Get("path"), cursor, limit)
+	if err != nil {
+		http.Error(w, `{"error":"failed to list cache entries"}`, http.StatusInternalServerError)
+		return
+	}
+	page := &models.CacheEntryPage{Entries: entries}
+	if next != 0 {
+		page.NextCursor = &next
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// GetEntry describes one cached resource of a route.
+func (h *CacheRuleHandler) GetEntry(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	route, ok := h.ownedRoute(w, r, member)
+	if !ok {
+		return
+	}
+
+	entry, err := h.cacheService.GetEntry(r.Context(), route.ID, chi.URLParam(r, "key"))
+	if errors.Is(err, services.ErrCacheEntryNotFound) {
+		http.Error(w, `{"error":"cache entry not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to get cache entry"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// Warm fetches paths of a route through the proxy so that their responses
+// are cached before clients ask for them.
+func (h *CacheRuleHandler) Warm(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if writeForbidden(w, services.Authorize(member, models.RoleEditor)) {
+		return
+	}
+
+	route, ok := h.ownedRoute(w, r, member)
+	if !ok {
+		return
+	}
+
+	var req models.WarmCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if len(req.Paths) == 0 || len(req.Paths) > maxWarmPaths {
+		http.Error(w, fmt.Sprintf(`{"error":"between 1 and %d paths are required"}`, maxWarmPaths), http.StatusBadRequest)
+		return
+	}
+
+	rule, err := h.service.GetByRouteID(r.Context(), route.ID)
+	if err != nil || !cachesMethod(rule, http.MethodGet) {
+		http.Error(w, `{"error":"route has no enabled cache rule for GET"}`, http.StatusBadRequest)
+		return
+	}
+	// Warm-up requests carry no credential, so entries keyed per caller
+	// would never be read.
+	pattern, err := services.ParseCacheKeyPattern(rule.CacheKeyPattern)
+	if err != nil || pattern.Includes("apikey") || pattern.Includes("org") || pattern.Includes("consumer") {
+		http.Error(w, `{"error":"routes whose cache key depends on the caller cannot be warmed"}`, http.StatusBadRequest)
+		return
+	}
 
-	if req.Pattern == "" {
-		req.Pattern = "cache:*"
+	header := http.Header{}
+	for name, value := range req.Headers {
+		header.Set(name, value)
 	}
+	results := h.proxy.Warm(r.Context(), route, req.Paths, header, req.Refresh, r.RemoteAddr)
 
-	if err := h.cacheService.Delete(r.Context(), req.Pattern); err != nil {
+	err = recordAudit(h.audit, r, member, "cache.warm", "route", route.ID, map[string]interface{}{
+		"paths":   req.Paths,
+		"refresh": req.Refresh,
+	})
+	if err != nil {
+		http.Error(w, `{"error":"failed to record audit entry"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// ownedRoute loads the route named by the id URL parameter, writing an error
+// response when it is invalid or belongs to another organization.
+func (h *CacheRuleHandler) ownedRoute(w http.ResponseWriter, r *http.Request, member *models.Membership) (*models.Route, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid route ID"}`, http.StatusBadRequest)
+		return nil, false
+	}
+
+	route, err := h.routeService.GetByID(r.Context(), member, id)
+	if writeForbidden(w, err) {
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"route not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return route, true
+}
+
+func (h *CacheRuleHandler) invalidate(w http.ResponseWriter, r *http.Request, member *models.Membership, routes []*models.Route, path string) {
+	routeIDs := make([]int64, 0, len(routes))
+	for _, route := range routes {
+		routeIDs = append(routeIDs, route.ID)
+	}
+	deleted, err := h.cacheService.InvalidateRoutes(r.Context(), routeIDs, path)
+	if err != nil {
 		http.Error(w, `{"error":"failed to invalidate cache"}`, http.StatusInternalServerError)
 		return
 	}
 
+	resourceID := ""
+	if len(routes) == 1 {
+		resourceID = strconv.FormatInt(routes[0].ID, 10)
+	}
+	err = recordAudit(h.audit, r, member, "cache.invalidate", "cache", resourceID, map[string]interface{}{
+		"route_ids": routeIDs,
+		"path":      path,
+		"deleted":   deleted,
+	})
+	if err != nil {
+		http.Error(w, `{"error":"failed to record audit entry"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "cache invalidated", "deleted": deleted})
+}
+
+// Purge deletes cached responses tagged with any of the given surrogate keys
+// across the organization's routes.
+func (h *CacheRuleHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if writeForbidden(w, services.Authorize(member, models.RoleEditor)) {
+		return
+	}
+
+	var req models.PurgeCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidatePurgeTags(req.Tags); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.cacheService.PurgeTags(r.Context(), member.OrgID, req.Tags)
+	if err != nil {
+		http.Error(w, `{"error":"failed to purge cache"}`, http.StatusInternalServerError)
+		return
+	}
+	err = recordAudit(h.audit, r, member, "cache.purge", "cache", "", map[string]interface{}{
+		"tags":    req.Tags,
+		"deleted": deleted,
+	})
+	if err != nil {
+		http.Error(w, `{"error":"failed to record audit entry"}`, http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"message": "cache invalidated"})
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "cache purged", "deleted": deleted})
 }
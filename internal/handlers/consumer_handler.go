@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gateway/internal/analytics"
+	"gateway/internal/middleware"
+	"gateway/internal/models"
+	"gateway/internal/services"
+	"net/http"
+)
+
+// consumerStatusRPM limits how often a key may poll its own status. It is
+// counted separately so that checking the quota does not consume it.
+const consumerStatusRPM = 60
+
+// ConsumerHandler serves the reserved /_gateway paths to API key holders.
+type ConsumerHandler struct {
+	rateLimiter  *services.RateLimiter
+	analytics    *analytics.Analytics
+	cacheService *services.CacheService
+}
+
+func NewConsumerHandler(rateLimiter *services.RateLimiter, analytics *analytics.Analytics, cacheService *services.CacheService) *ConsumerHandler {
+	return &ConsumerHandler{
+		rateLimiter:  rateLimiter,
+		analytics:    analytics,
+		cacheService: cacheService,
+	}
+}
+
+func (h *ConsumerHandler) Me(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := r.Context().Value(middleware.APIKeyContextKey).(*models.APIKey)
+	if !ok {
+		http.Error(w, `{"error":"missing API key in context"}`, http.StatusInternalServerError)
+		return
+	}
+
+	allowed, err := h.rateLimiter.Allow(r.Context(), fmt.Sprintf("me:%d", apiKey.ID), consumerStatusRPM)
+	if err != nil {
+		http.Error(w, `{"error":"rate limit check failed"}`, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", consumerStatusRPM))
+		http.Error(w, `{"error":"rate limit exceeded"}`, http.StatusTooManyRequests)
+		return
+	}
+
+	used, err := h.rateLimiter.GetCount(r.Context(), middleware.APIKeyRateLimitKey(apiKey))
+	if err != nil {
+		http.Error(w, `{"error":"failed to get rate limit state"}`, http.StatusInternalServerError)
+		return
+	}
+
+	lastHour, last24Hours, err := h.analytics.GetAPIKeySummaries(r.Context(), apiKey.ID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to get usage"}`, http.StatusInternalServerError)
+		return
+	}
+
+	remaining := int64(apiKey.RateLimitRPM) - used
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	status := &models.ConsumerStatus{
+		KeyID: apiKey.ID,
+		Name:  apiKey.Name,
+		Tier:  apiKey.Tier,
+		RateLimit: models.RateLimitStatus{
+			Limit:     apiKey.RateLimitRPM,
+			Used:      used,
+			Remaining: remaining,
+			ResetAt:   h.rateLimiter.ResetAt(),
+		},
+		RequestCount: apiKey.RequestCount,
+		LastUsedAt:   apiKey.LastUsedAt,
+		LastHour:     *lastHour,
+		Last24Hours:  *last24Hours,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(status)
+}
+
+// Purge lets backends holding a key with cache_purge delete cached responses
+// by tag across their organization's routes.
+func (h *ConsumerHandler) Purge(w http.ResponseWriter, r *http.Request) {
+	apiKey, ok := r.Context().Value(middleware.APIKeyContextKey).(*models.APIKey)
+	if !ok {
+		http.Error(w, `{"error":"missing API key in context"}`, http.StatusInternalServerError)
+		return
+	}
+	if !apiKey.CachePurge {
+		http.Error(w, `{"error":"API key is not allowed to purge the cache"}`, http.StatusForbidden)
+		return
+	}
+
+	var req models.PurgeCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+	if err := services.ValidatePurgeTags(req.Tags); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.cacheService.PurgeTags(r.Context(), apiKey.OrgID, req.Tags)
+	if err != nil {
+		http.Error(w, `{"error":"failed to purge cache"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"message": "cache purged", "deleted": deleted})
+}
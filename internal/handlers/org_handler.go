@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway/internal/middleware"
+	"gateway/internal/models"
+	"gateway/internal/services"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type OrgHandler struct {
+	service *services.OrgService
+	audit   *services.AuditService
+}
+
+func NewOrgHandler(service *services.OrgService, audit *services.AuditService) *OrgHandler {
+	return &OrgHandler{service: service, audit: audit}
+}
+
+// writeForbidden answers with 403 when err is a failed role check.
+func writeForbidden(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, services.ErrForbidden) {
+		http.Error(w, `{"error":"insufficient role for this organization"}`, http.StatusForbidden)
+		return true
+	}
+	return false
+}
+
+func (h *OrgHandler) List(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	orgs, err := h.service.ListForUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, `{"error":"failed to list organizations"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+func (h *OrgHandler) Create(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+
+	var req models.CreateOrganizationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	org, err := h.service.Create(r.Context(), userID, &req, auditor(h.audit, r, userID, "org.create", "organization"))
+	if errors.Is(err, services.ErrInvalidMembership) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to create organization"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(org)
+}
+
+func (h *OrgHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.service.Delete(r.Context(), member, auditor(h.audit, r, member.UserID, "org.delete", "organization"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMembership) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to delete organization"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *OrgHandler) ListMembers(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	members, err := h.service.ListMembers(r.Context(), member)
+	if writeForbidden(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to list members"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(members)
+}
+
+func (h *OrgHandler) AddMember(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.AddMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	added, err := h.service.AddMember(r.Context(), member, &req, auditor(h.audit, r, member.UserID, "org_member.add", "org_member"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMembership) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to add member"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(added)
+}
+
+func (h *OrgHandler) UpdateMember(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	var req models.UpdateMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	userID := chi.URLParam(r, "userID")
+	updated, err := h.service.UpdateMemberRole(r.Context(), member, userID, &req, auditor(h.audit, r, member.UserID, "org_member.update", "org_member"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrNotMember) {
+		http.Error(w, `{"error":"member not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMembership) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to update member"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+func (h *OrgHandler) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	member, ok := h.membership(w, r)
+	if !ok {
+		return
+	}
+
+	userID := chi.URLParam(r, "userID")
+	err := h.service.RemoveMember(r.Context(), member, userID, auditor(h.audit, r, member.UserID, "org_member.remove", "org_member"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrNotMember) {
+		http.Error(w, `{"error":"member not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, services.ErrInvalidMembership) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to remove member"}`, http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// membership loads the caller's membership in the organization named in the
+// URL, answering the request itself if there is none.
+func (h *OrgHandler) membership(w http.ResponseWriter, r *http.Request) (*models.Membership, bool) {
+	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
+	if !ok || userID == "" {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return nil, false
+	}
+
+	orgID, err := strconv.ParseInt(chi.URLParam(r, "orgID"), 10, 64)
+	if err != nil {
+		http.Error(w, `{"error":"invalid organization ID"}`, http.StatusBadRequest)
+		return nil, false
+	}
+
+	member, err := h.service.GetMembership(r.Context(), orgID, userID)
+	if errors.Is(err, services.ErrNotMember) {
+		http.Error(w, `{"error":"organization not found"}`, http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, `{"error":"failed to load organization"}`, http.StatusInternalServerError)
+		return nil, false
+	}
+	return member, true
+}
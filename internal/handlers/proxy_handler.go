@@ -1,14 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"gateway/internal/analytics"
 	"gateway/internal/middleware"
 	"gateway/internal/models"
 	"gateway/internal/services"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 )
 
 type ProxyHandler struct {
@@ -17,6 +25,8 @@ type ProxyHandler struct {
 	cacheService     *services.CacheService
 	cacheRuleService *services.CacheRuleService
 	analytics        *analytics.Analytics
+	// fetches coalesces concurrent cache misses by cache key.
+	fetches singleflight.Group
 }
 
 func NewProxyHandler(
@@ -39,96 +49,555 @@ func (h *ProxyHandler) Forward(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 	apiKey, _ := r.Context().Value(middleware.APIKeyContextKey).(*models.APIKey)
 
-	path := r.URL.Path
-	route, err := h.routeService.GetByPath(r.Context(), path)
+	route, _ := r.Context().Value(middleware.RouteContextKey).(*models.Route)
+	if route == nil {
+		http.Error(w, `{"error":"route not found"}`, http.StatusNotFound)
+		h.trackEvent(nil, apiKey, http.StatusNotFound, startTime, "", "", r, "")
+		return
+	}
+
+	if apiKey != nil {
+		if accessErr := services.AuthorizeRoute(apiKey, route, r.Method, r.URL.Path); accessErr != nil {
+			middleware.WriteAccessError(w, accessErr)
+			h.trackEvent(route, apiKey, http.StatusForbidden, startTime, "", "", r, accessErr.Reason)
+			return
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		middleware.WriteBodyError(w, services.ErrBodyTooLarge)
+		h.trackEvent(route, apiKey, services.ErrBodyTooLarge.StatusCode, startTime, "", "", r, services.ErrBodyTooLarge.Reason)
+		return
+	}
 	if err != nil {
-		for _, prefix := range []string{"/api/", "/v1/", "/v2/"} {
-			if strings.HasPrefix(path, prefix) {
-				testPath := strings.TrimPrefix(path, prefix)
-				if testRoute, testErr := h.routeService.GetByPath(r.Context(), "/"+testPath); testErr == nil {
-					route = testRoute
-					break
-				}
+		http.Error(w, `{"error":"failed to read request body"}`, http.StatusBadRequest)
+		h.trackEvent(route, apiKey, http.StatusBadRequest, startTime, "", "", r, "")
+		return
+	}
+	if bodyErr := services.CheckRequestBody(route, r.Header.Get("Content-Type"), body); bodyErr != nil {
+		middleware.WriteBodyError(w, bodyErr)
+		h.trackEvent(route, apiKey, bodyErr.StatusCode, startTime, "", "", r, bodyErr.Reason)
+		return
+	}
+
+	cacheRule, _ := h.cacheRuleService.GetByRouteID(r.Context(), route.ID)
+
+	var cacheKey string
+	// lookup is the request as the cache sees it. HEAD requests are answered
+	// from, and fill, the GET entry, as the gateway fetches GET upstream.
+	lookup := r
+	cacheable := cachesMethod(cacheRule, r.Method)
+	if cacheable {
+		if r.Method == http.MethodHead {
+			lookup = r.Clone(r.Context())
+			lookup.Method = http.MethodGet
+		}
+		// Patterns are validated when saved; skip caching if one is not.
+		pattern, err := services.ParseCacheKeyPattern(cacheRule.CacheKeyPattern)
+		if err != nil {
+			cacheable = false
+		} else {
+			orgID := route.OrgID
+			if apiKey != nil {
+				orgID = apiKey.OrgID
+			}
+			cacheKey = h.cacheService.GenerateKey(orgID, route.ID, pattern, cacheKeyInput(lookup, body, apiKey))
+		}
+	}
+	// stored is an entry that cannot answer the request by itself. It is
+	// revalidated upstream, and may still be served if the upstream fails.
+	var stored *services.CachedResponse
+	var storedTier string
+	if cacheable {
+		if cached, tier, err := h.cacheService.Get(r.Context(), cacheKey, r.Header); err == nil && tier != "" {
+			now := time.Now()
+			if services.UsableForRequest(r.Header, cached, now) {
+				status := writeCachedResponse(w, r, cached, now, cacheStatusHit, "")
+				h.trackEvent(route, apiKey, status, startTime, cacheStatusHit, tier, r, "")
+				return
 			}
+			if !cached.Fresh(now) && services.ServableStale(r.Header, cached, cached.StaleWhileRevalidate, now) {
+				h.revalidate(lookup, route, body, cacheKey, cacheRule, cached)
+				status := writeCachedResponse(w, r, cached, now, cacheStatusStale, `110 - "Response is Stale"`)
+				h.trackEvent(route, apiKey, status, startTime, cacheStatusStale, tier, r, "")
+				return
+			}
+			stored, storedTier = cached, tier
 		}
 	}
 
-	if route == nil {
-		http.Error(w, `{"error":"route not found"}`, http.StatusNotFound)
-		h.trackEvent(nil, apiKey, http.StatusNotFound, startTime, false, r.RemoteAddr)
+	if !cacheable {
+		status, err := h.stream(w, r, route, body)
+		if err != nil {
+			http.Error(w, `{"error":"backend request failed"}`, http.StatusBadGateway)
+			h.trackEvent(route, apiKey, http.StatusBadGateway, startTime, "", "", r, "")
+			return
+		}
+		h.trackEvent(route, apiKey, status, startTime, cacheStatusMiss, "", r, "")
 		return
 	}
 
-	body, _ := io.ReadAll(r.Body)
-	cacheKey := h.cacheService.GenerateKey(r.URL.Path, r.Method, string(body))
-	cacheRule, _ := h.cacheRuleService.GetByRouteID(r.Context(), route.ID)
+	// Concurrent misses for the same key share one upstream request. The
+	// fetch outlives any one caller, so it is detached from their contexts.
+	// Do reports shared to the caller that ran the fetch as well, so ran
+	// tells that caller apart from the ones that waited on it.
+	var resp *upstreamResponse
+	var ran bool
+	v, err, _ := h.fetches.Do(cacheKey, func() (interface{}, error) {
+		ran = true
+		return h.fetch(context.WithoutCancel(r.Context()), lookup, route, body, cacheKey, cacheRule, stored)
+	})
+	if err == nil {
+		resp = v.(*upstreamResponse)
+		// Only responses fit for the shared cache, in the representation
+		// this request asked for, are handed to other callers.
+		if !ran && (resp.entry == nil || !services.SameVariant(resp.entry.Header, resp.reqHeader, r.Header)) {
+			resp, err = h.fetch(r.Context(), lookup, route, body, cacheKey, cacheRule, stored)
+		}
+	}
 
-	if r.Method == "GET" && cacheRule != nil && cacheRule.Enabled {
-		if cached, hit, err := h.cacheService.Get(r.Context(), cacheKey); err == nil && hit {
-			w.Header().Set("Content-Type", "application/json")
-			w.Header().Set("X-Cache", "HIT")
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte(cached))
-			h.trackEvent(&route.ID, apiKey, http.StatusOK, startTime, true, r.RemoteAddr)
+	if (err != nil || resp.statusCode >= http.StatusInternalServerError) && stored != nil {
+		if now := time.Now(); services.ServableStale(r.Header, stored, stored.StaleIfError, now) {
+			status := writeCachedResponse(w, r, stored, now, cacheStatusStale, `111 - "Revalidation Failed"`)
+			h.trackEvent(route, apiKey, status, startTime, cacheStatusStale, storedTier, r, "")
 			return
 		}
 	}
 
+	if err != nil {
+		http.Error(w, `{"error":"backend request failed"}`, http.StatusBadGateway)
+		h.trackEvent(route, apiKey, http.StatusBadGateway, startTime, "", "", r, "")
+		return
+	}
+
+	if resp.entry != nil {
+		tier := ""
+		if resp.cacheStatus == cacheStatusHit {
+			tier = services.CacheTierRedis
+		}
+		status := writeCachedResponse(w, r, resp.entry, time.Now(), resp.cacheStatus, "")
+		h.trackEvent(route, apiKey, status, startTime, resp.cacheStatus, tier, r, "")
+		return
+	}
+
+	// Client preconditions are not forwarded on cacheable routes, so they
+	// are checked here.
+	notModified := r.Method != http.MethodPost && resp.statusCode == http.StatusOK && services.NotModified(r.Header, resp.header)
+	copyResponseHeaders(w, resp.header, notModified)
+	for _, name := range services.CacheTagHeaders {
+		w.Header().Del(name)
+	}
+
+	w.Header().Set("X-Cache", resp.cacheStatus)
+	status := resp.statusCode
+	if notModified {
+		status = http.StatusNotModified
+	}
+	w.WriteHeader(status)
+	if !notModified {
+		w.Write(resp.body)
+	}
+
+	h.trackEvent(route, apiKey, status, startTime, resp.cacheStatus, "", r, "")
+}
+
+// stream forwards a request on a route without caching and copies the
+// upstream response to the client as it arrives. Responses of unknown
+// length, such as event streams, are flushed after every read. It returns
+// the response status, or an error if no response was received.
+func (h *ProxyHandler) stream(w http.ResponseWriter, r *http.Request, route *models.Route, body []byte) (int, error) {
 	resp, err := h.proxyService.Forward(
 		r.Context(),
 		route.BackendURLs,
 		r.Method,
 		r.URL.Path,
+		r.URL.RawQuery,
 		route.Path,
 		r.Header,
 		body,
 		route.TimeoutMs,
 	)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
 
+	copyResponseHeaders(w, resp.Header, false)
+	for _, name := range services.CacheTagHeaders {
+		w.Header().Del(name)
+	}
+	w.Header().Set("X-Cache", cacheStatusMiss)
+	w.WriteHeader(resp.StatusCode)
+
+	rc := http.NewResponseController(w)
+	flush := resp.ContentLength < 0
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				break
+			}
+			if flush {
+				rc.Flush()
+			}
+		}
+		if readErr != nil {
+			// The status is already sent; a body cut short by the upstream
+			// reaches the client as such.
+			break
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// revalidate refreshes a stale entry in the background. It joins the
+// coalesced fetch for the key, so only one refresh runs however many
+// requests are served the stale entry meanwhile.
+func (h *ProxyHandler) revalidate(r *http.Request, route *models.Route, body []byte, cacheKey string, cacheRule *models.CacheRule, stored *services.CachedResponse) {
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	go h.fetches.Do(cacheKey, func() (interface{}, error) {
+		return h.fetch(req.Context(), req, route, body, cacheKey, cacheRule, stored)
+	})
+}
+
+// warmConcurrency bounds the requests a single cache warm-up runs at once.
+const warmConcurrency = 4
+
+// Warm replays GET requests for paths on the route through Forward, as a
+// request without an API key from remoteAddr, so that the responses are
+// cached exactly as they would be for live traffic. Paths must resolve to
+// the route. With refresh, stored responses are revalidated upstream instead
+// of being served from the cache.
+func (h *ProxyHandler) Warm(ctx context.Context, route *models.Route, paths []string, header http.Header, refresh bool, remoteAddr string) []models.WarmCacheResult {
+	results := make([]models.WarmCacheResult, len(paths))
+	var g errgroup.Group
+	g.SetLimit(warmConcurrency)
+	for i, path := range paths {
+		i, path := i, path
+		g.Go(func() error {
+			results[i] = h.warmPath(ctx, route, path, header, refresh, remoteAddr)
+			return nil
+		})
+	}
+	g.Wait()
+	return results
+}
+
+func (h *ProxyHandler) warmPath(ctx context.Context, route *models.Route, path string, header http.Header, refresh bool, remoteAddr string) models.WarmCacheResult {
+	result := models.WarmCacheResult{Path: path}
+	u, err := url.ParseRequestURI(path)
+	if err != nil || u.Host != "" || !strings.HasPrefix(path, "/") {
+		result.Error = "invalid path"
+		return result
+	}
+	if resolved, err := h.routeService.Resolve(ctx, u.Path); err != nil || resolved.ID != route.ID {
+		result.Error = "path does not belong to route"
+		return result
+	}
+
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, middleware.RouteContextKey, route), http.MethodGet, path, http.NoBody)
 	if err != nil {
-		http.Error(w, `{"error":"backend request failed"}`, http.StatusBadGateway)
-		h.trackEvent(&route.ID, apiKey, http.StatusBadGateway, startTime, false, r.RemoteAddr)
-		return
+		result.Error = "invalid path"
+		return result
+	}
+	req.Header = header.Clone()
+	if refresh {
+		req.Header.Set("Cache-Control", "no-cache")
 	}
+	req.RemoteAddr = remoteAddr
+
+	rec := &discardResponseWriter{header: http.Header{}, status: http.StatusOK}
+	h.Forward(rec, req)
+	result.StatusCode = rec.status
+	result.Cache = rec.header.Get("X-Cache")
+	return result
+}
 
+// discardResponseWriter keeps the status and headers of a response and
+// drops its body.
+type discardResponseWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardResponseWriter) Header() http.Header         { return w.header }
+func (w *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardResponseWriter) WriteHeader(status int)      { w.status = status }
+
+// upstreamResponse is a response fetched on behalf of one or more requests.
+type upstreamResponse struct {
+	statusCode int
+	header     http.Header
+	body       []byte
+	// reqHeader is the header of the request that fetched the response.
+	reqHeader http.Header
+	// entry is the response as stored in the cache. It is only set when the
+	// response could be stored, so that it is not specific to the client
+	// that fetched it.
+	entry *services.CachedResponse
+	// cacheStatus is MISS, REVALIDATED when the upstream confirmed a stored
+	// response, or HIT when another gateway instance fetched it.
+	cacheStatus string
+}
+
+// fetch forwards the request for a cacheable route upstream. It stores the
+// response if it may be cached and, when another gateway instance is
+// already fetching the same key, waits for that response instead. A stored
+// response is revalidated with a conditional request, and refreshed when
+// the upstream answers 304 Not Modified.
+func (h *ProxyHandler) fetch(ctx context.Context, r *http.Request, route *models.Route, body []byte, cacheKey string, cacheRule *models.CacheRule, stored *services.CachedResponse) (*upstreamResponse, error) {
+	release, acquired := h.cacheService.AcquireFill(ctx, cacheKey)
+	if !acquired {
+		if entry, found := h.cacheService.AwaitFill(ctx, cacheKey, r.Header); found {
+			// The body is left out as it may be compressed; responses with
+			// an entry are written from the entry.
+			return &upstreamResponse{
+				statusCode:  entry.StatusCode,
+				header:      entry.Header,
+				reqHeader:   r.Header,
+				entry:       entry,
+				cacheStatus: cacheStatusHit,
+			}, nil
+		}
+	}
+	defer release()
+	header := services.RevalidationHeaders(r.Header, stored)
+
+	resp, err := h.proxyService.Forward(
+		ctx,
+		route.BackendURLs,
+		r.Method,
+		r.URL.Path,
+		r.URL.RawQuery,
+		route.Path,
+		header,
+		body,
+		route.TimeoutMs,
+	)
+	if err != nil {
+		return nil, err
+	}
 	defer resp.Body.Close()
 	respBody, _ := io.ReadAll(resp.Body)
 
-	if r.Method == "GET" && cacheRule != nil && cacheRule.Enabled && resp.StatusCode == http.StatusOK {
-		ttl := time.Duration(cacheRule.TTLSeconds) * time.Second
-		h.cacheService.Set(r.Context(), cacheKey, string(respBody), ttl)
+	result := &upstreamResponse{
+		statusCode:  resp.StatusCode,
+		header:      resp.Header,
+		body:        respBody,
+		reqHeader:   r.Header,
+		cacheStatus: cacheStatusMiss,
 	}
 
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+	revalidated := resp.StatusCode == http.StatusNotModified && stored != nil
+	if revalidated {
+		storedBody, err := stored.DecodedBody()
+		if err != nil {
+			return nil, err
 		}
+		result.statusCode = stored.StatusCode
+		result.header = services.MergeNotModified(stored.Header, resp.Header)
+		result.body = storedBody
+		result.cacheStatus = cacheStatusRevalidated
 	}
 
-	w.Header().Set("X-Cache", "MISS")
-	w.WriteHeader(resp.StatusCode)
-	w.Write(respBody)
+	if entry, ok := newCacheEntry(r, cacheRule, result.statusCode, result.header, result.body, time.Now()); ok {
+		if revalidated {
+			// The merged headers already hold the stored ETag and lack the
+			// tag headers, which were removed when it was stored.
+			entry.GeneratedETag = stored.GeneratedETag && resp.Header.Get("ETag") == ""
+			if len(entry.Tags) == 0 {
+				entry.Tags = stored.Tags
+			}
+		}
+		h.cacheService.Set(ctx, cacheKey, route, r, entry, cacheRule.MaxEntryBytes)
+		result.entry = entry
+	}
+	return result, nil
+}
+
+// newCacheEntry builds the cache entry for a response, if it may be stored:
+// 200s for the rule's TTL, and the rule's negative statuses for its
+// negative TTL, without a stale window.
+func newCacheEntry(r *http.Request, cacheRule *models.CacheRule, statusCode int, header http.Header, body []byte, now time.Time) (*services.CachedResponse, bool) {
+	negative := statusCode != http.StatusOK
+	defaultTTL := time.Duration(cacheRule.TTLSeconds) * time.Second
+	if negative {
+		if cacheRule.NegativeTTLSeconds <= 0 || !containsStatus(cacheRule.NegativeStatusCodes, statusCode) {
+			return nil, false
+		}
+		defaultTTL = time.Duration(cacheRule.NegativeTTLSeconds) * time.Second
+	}
+
+	ttl, ok := services.ResponseFreshness(r.Header, header, defaultTTL, now)
+	if !ok {
+		return nil, false
+	}
+
+	entry := services.NewCachedResponse(statusCode, header, body, ttl, now)
+	entry.Request = r.Method + " " + r.URL.RequestURI()
+	if !negative {
+		entry.StaleWhileRevalidate, entry.StaleIfError = services.StaleWindows(
+			header,
+			time.Duration(cacheRule.StaleWhileRevalidateSeconds)*time.Second,
+			time.Duration(cacheRule.StaleIfErrorSeconds)*time.Second,
+		)
+	}
+	return entry, true
+}
+
+func containsStatus(codes []int, statusCode int) bool {
+	for _, code := range codes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
 
-	h.trackEvent(&route.ID, apiKey, resp.StatusCode, startTime, false, r.RemoteAddr)
+// cachesMethod reports whether an enabled rule covers the request method.
+func cachesMethod(cacheRule *models.CacheRule, method string) bool {
+	if cacheRule == nil || !cacheRule.Enabled {
+		return false
+	}
+	for _, m := range cacheRule.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKeyInput collects what a cache key pattern may use from the request
+// and the credential it was authenticated with.
+func cacheKeyInput(r *http.Request, body []byte, apiKey *models.APIKey) *services.CacheKeyInput {
+	in := &services.CacheKeyInput{Request: r, Body: body}
+	if apiKey != nil {
+		in.APIKeyID = apiKey.ID
+		in.OrgID = apiKey.OrgID
+	}
+	if consumer, ok := r.Context().Value(middleware.ConsumerContextKey).(*middleware.Consumer); ok {
+		in.Consumer = consumer.Issuer + " " + consumer.Subject
+	}
+	return in
 }
 
-func (h *ProxyHandler) trackEvent(routeID *int64, apiKey *models.APIKey, statusCode int, startTime time.Time, cacheHit bool, ipAddr string) {
-	var apiKeyID *int64
+// X-Cache values, also recorded in analytics.
+const (
+	cacheStatusHit         = "HIT"
+	cacheStatusMiss        = "MISS"
+	cacheStatusStale       = "STALE"
+	cacheStatusRevalidated = "REVALIDATED"
+)
+
+// writeCachedResponse answers from the cache with the stored status and
+// headers, adding Age and, when the upstream sent none, a Cache-Control
+// carrying the remaining freshness. Stale responses carry a Warning.
+// GET and HEAD requests whose If-None-Match or If-Modified-Since matches get
+// a 304. Entries compressed for storage are sent as they are to clients that
+// accept their encoding, and decompressed for others. It returns the status
+// written; HEAD bodies are dropped by net/http.
+func writeCachedResponse(w http.ResponseWriter, r *http.Request, entry *services.CachedResponse, now time.Time, cacheStatus, warning string) int {
+	notModified := r.Method != http.MethodPost && entry.StatusCode == http.StatusOK && services.NotModified(r.Header, entry.Header)
+	body := entry.Body
+	sendEncoded := entry.Encoding != "" && services.AcceptsEncoding(r.Header, entry.Encoding)
+	if entry.Encoding != "" && !sendEncoded && !notModified {
+		var err error
+		if body, err = entry.DecodedBody(); err != nil {
+			http.Error(w, `{"error":"failed to read cached response"}`, http.StatusInternalServerError)
+			return http.StatusInternalServerError
+		}
+	}
+
+	copyResponseHeaders(w, entry.Header, notModified)
+	if entry.Encoding != "" {
+		services.AddVary(w.Header(), "Accept-Encoding")
+	}
+	if sendEncoded {
+		// The compressed bytes are a different representation of the same
+		// content, so a strong validator no longer applies.
+		if etag := w.Header().Get("ETag"); etag != "" {
+			w.Header().Set("ETag", services.WeakETag(etag))
+		}
+		if !notModified {
+			w.Header().Set("Content-Encoding", entry.Encoding)
+			w.Header().Del("Content-Length")
+		}
+	}
+
+	age := entry.Age(now)
+	w.Header().Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	if w.Header().Get("Cache-Control") == "" {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int64(entry.TTL/time.Second)))
+	}
+	if warning != "" {
+		w.Header().Add("Warning", warning)
+	}
+	w.Header().Set("X-Cache", cacheStatus)
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+		return http.StatusNotModified
+	}
+	w.WriteHeader(entry.StatusCode)
+	w.Write(body)
+	return entry.StatusCode
+}
+
+// copyResponseHeaders copies upstream or stored headers to the response,
+// only those a 304 repeats when notModified is set.
+func copyResponseHeaders(w http.ResponseWriter, header http.Header, notModified bool) {
+	if notModified {
+		for _, name := range services.NotModifiedHeaders {
+			for _, value := range header.Values(name) {
+				w.Header().Add(name, value)
+			}
+		}
+		return
+	}
+	for key, values := range header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+}
+
+func (h *ProxyHandler) trackEvent(route *models.Route, apiKey *models.APIKey, statusCode int, startTime time.Time, cacheStatus, cacheTier string, r *http.Request, reason string) {
+	var routeID, apiKeyID, orgID *int64
 	var userID string
+	if route != nil {
+		routeID = &route.ID
+		orgID = &route.OrgID
+		userID = route.UserID
+	}
 	if apiKey != nil {
 		apiKeyID = &apiKey.ID
+		orgID = &apiKey.OrgID
 		userID = apiKey.UserID
 	}
 
+	// Resolved as the auth middleware does, so that last_used_ip holds a
+	// plain IPv4 or IPv6 address.
+	var ipAddr string
+	if clientIP := middleware.ClientIP(r); clientIP != nil {
+		ipAddr = clientIP.String()
+	}
+
 	event := &models.AnalyticsEvent{
 		Timestamp:  time.Now(),
 		RouteID:    routeID,
 		APIKeyID:   apiKeyID,
+		OrgID:      orgID,
 		UserID:     userID,
 		StatusCode: statusCode,
 		LatencyMs:  time.Since(startTime).Milliseconds(),
-		CacheHit:   cacheHit,
-		IPAddress:  strings.Split(ipAddr, ":")[0],
+		CacheHit:   cacheStatus == cacheStatusHit || cacheStatus == cacheStatusStale,
+		CacheStale: cacheStatus == cacheStatusStale,
+		CacheTier:  cacheTier,
+		IPAddress:  ipAddr,
+		Reason:     reason,
 	}
 
 	h.analytics.TrackRequest(event)
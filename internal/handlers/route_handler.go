@@ -2,6 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"gateway/internal/middleware"
 	"gateway/internal/models"
 	"gateway/internal/services"
@@ -13,15 +15,16 @@ import (
 
 type RouteHandler struct {
 	service *services.RouteService
+	audit   *services.AuditService
 }
 
-func NewRouteHandler(service *services.RouteService) *RouteHandler {
-	return &RouteHandler{service: service}
+func NewRouteHandler(service *services.RouteService, audit *services.AuditService) *RouteHandler {
+	return &RouteHandler{service: service, audit: audit}
 }
 
 func (h *RouteHandler) Create(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -32,7 +35,14 @@ func (h *RouteHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	route, err := h.service.Create(r.Context(), userID, &req)
+	route, err := h.service.Create(r.Context(), member, &req, auditor(h.audit, r, member.UserID, "route.create", "route"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidRoute) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to create route"}`, http.StatusInternalServerError)
 		return
@@ -44,13 +54,16 @@ func (h *RouteHandler) Create(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *RouteHandler) List(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
 
-	routes, err := h.service.List(r.Context(), userID)
+	routes, err := h.service.List(r.Context(), member)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to list routes"}`, http.StatusInternalServerError)
 		return
@@ -61,8 +74,8 @@ func (h *RouteHandler) List(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *RouteHandler) Get(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -74,7 +87,10 @@ func (h *RouteHandler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	route, err := h.service.GetByID(r.Context(), userID, id)
+	route, err := h.service.GetByID(r.Context(), member, id)
+	if writeForbidden(w, err) {
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"route not found"}`, http.StatusNotFound)
 		return
@@ -85,8 +101,8 @@ func (h *RouteHandler) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *RouteHandler) Update(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -104,7 +120,14 @@ func (h *RouteHandler) Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	route, err := h.service.Update(r.Context(), userID, id, &req)
+	route, err := h.service.Update(r.Context(), member, id, &req, auditor(h.audit, r, member.UserID, "route.update", "route"))
+	if writeForbidden(w, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidRoute) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, `{"error":"failed to update route"}`, http.StatusInternalServerError)
 		return
@@ -115,8 +138,8 @@ func (h *RouteHandler) Update(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *RouteHandler) Delete(w http.ResponseWriter, r *http.Request) {
-	userID, ok := r.Context().Value(middleware.UserIDContextKey).(string)
-	if !ok || userID == "" {
+	member, ok := r.Context().Value(middleware.MembershipContextKey).(*models.Membership)
+	if !ok {
 		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
 		return
 	}
@@ -128,7 +151,10 @@ func (h *RouteHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.Delete(r.Context(), userID, id); err != nil {
+	if err := h.service.Delete(r.Context(), member, id, auditor(h.audit, r, member.UserID, "route.delete", "route")); err != nil {
+		if writeForbidden(w, err) {
+			return
+		}
 		http.Error(w, `{"error":"failed to delete route"}`, http.StatusInternalServerError)
 		return
 	}
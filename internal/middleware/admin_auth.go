@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AdminAuthenticator authenticates requests to the /admin API and stores the
+// caller's user ID under UserIDContextKey.
+type AdminAuthenticator interface {
+	Middleware() func(http.Handler) http.Handler
+}
+
+// bearerToken reads the token from an "Authorization: Bearer" header,
+// answering the request itself when there is none.
+func bearerToken(w http.ResponseWriter, r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		http.Error(w, `{"error":"missing authorization header"}`, http.StatusUnauthorized)
+		return "", false
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		http.Error(w, `{"error":"invalid authorization format"}`, http.StatusUnauthorized)
+		return "", false
+	}
+	return parts[1], true
+}
+
+type staticToken struct {
+	hash   [sha256.Size]byte
+	userID string
+}
+
+// StaticTokenAuth accepts a fixed set of bearer tokens from configuration,
+// each mapped to the user ID that owns the resources it manages.
+type StaticTokenAuth struct {
+	tokens []staticToken
+}
+
+// NewStaticTokenAuth takes a map of user ID to token.
+func NewStaticTokenAuth(tokens map[string]string) (*StaticTokenAuth, error) {
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no admin tokens configured")
+	}
+
+	a := &StaticTokenAuth{}
+	for userID, token := range tokens {
+		if len(token) < 32 {
+			return nil, fmt.Errorf("admin token for %q must be at least 32 characters", userID)
+		}
+		a.tokens = append(a.tokens, staticToken{hash: sha256.Sum256([]byte(token)), userID: userID})
+	}
+	return a, nil
+}
+
+func (a *StaticTokenAuth) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(w, r)
+			if !ok {
+				return
+			}
+
+			// Compare against every token so the time taken does not reveal
+			// which one, if any, was close.
+			hash := sha256.Sum256([]byte(token))
+			userID := ""
+			for _, t := range a.tokens {
+				if subtle.ConstantTimeCompare(hash[:], t.hash[:]) == 1 {
+					userID = t.userID
+				}
+			}
+			if userID == "" {
+				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
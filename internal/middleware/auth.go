@@ -2,36 +2,86 @@ package middleware
 
 import (
 	"context"
+	"fmt"
+	"gateway/internal/analytics"
+	"gateway/internal/models"
 	"gateway/internal/services"
+	"net"
 	"net/http"
-	"strings"
+	"time"
 )
 
 const APIKeyContextKey contextKey = "apikey"
 
-func APIKeyAuth(apiKeyService *services.APIKeyService) func(http.Handler) http.Handler {
+// APIKeyAuth authenticates proxy requests by API key. The key is read from
+// the route's configured location, or defaultSource when the route has none,
+// and removed from the request before it reaches the backend.
+func APIKeyAuth(apiKeyService *services.APIKeyService, analytics *analytics.Analytics, defaultSource KeySource) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, `{"error":"missing authorization header"}`, http.StatusUnauthorized)
-				return
-			}
+			route, _ := r.Context().Value(RouteContextKey).(*models.Route)
+			source := routeKeySource(route, defaultSource)
 
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, `{"error":"invalid authorization format"}`, http.StatusUnauthorized)
+			key, err := source.extract(r)
+			if err != nil {
+				http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusUnauthorized)
 				return
 			}
 
-			apiKey, err := apiKeyService.GetByKey(r.Context(), parts[1])
+			apiKey, err := apiKeyService.GetByKey(r.Context(), key)
 			if err != nil {
 				http.Error(w, `{"error":"invalid API key"}`, http.StatusUnauthorized)
 				return
 			}
 
+			if rejectRestricted(w, r, apiKey, analytics) {
+				return
+			}
+
 			ctx := context.WithValue(r.Context(), APIKeyContextKey, apiKey)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			r = r.Clone(ctx)
+			source.strip(r)
+			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// rejectRestricted enforces the key's IP and origin restrictions, answering
+// and recording the request itself when it is not allowed.
+func rejectRestricted(w http.ResponseWriter, r *http.Request, apiKey *models.APIKey, analytics *analytics.Analytics) bool {
+	clientIP := ClientIP(r)
+	accessErr := services.CheckRestrictions(apiKey, clientIP, r.Header.Get("Origin"), r.Header.Get("Referer"))
+	if accessErr == nil {
+		return false
+	}
+
+	WriteAccessError(w, accessErr)
+	ipAddr := r.RemoteAddr
+	if clientIP != nil {
+		ipAddr = clientIP.String()
+	}
+	analytics.TrackRequest(&models.AnalyticsEvent{
+		Timestamp:  time.Now(),
+		APIKeyID:   &apiKey.ID,
+		OrgID:      &apiKey.OrgID,
+		UserID:     apiKey.UserID,
+		StatusCode: http.StatusForbidden,
+		IPAddress:  ipAddr,
+		Reason:     accessErr.Reason,
+	})
+	return true
+}
+
+// ClientIP returns the caller's address as resolved by chi's RealIP
+// middleware, which may leave RemoteAddr with or without a port.
+func ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func WriteAccessError(w http.ResponseWriter, err *services.AccessError) {
+	http.Error(w, fmt.Sprintf(`{"error":%q,"reason":%q}`, err.Message, err.Reason), http.StatusForbidden)
+}
@@ -2,14 +2,9 @@ package middleware
 
 import (
 	"context"
-	"crypto/rsa"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
-	"math/big"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -19,153 +14,85 @@ type contextKey string
 
 const UserIDContextKey contextKey = "user_id"
 
-type ClerkAuth struct {
-	publicKeys map[string]*rsa.PublicKey
-	mu         sync.RWMutex
-	jwksURL    string
+// ClerkAuthConfig controls which Clerk session tokens are accepted. Issuer
+// defaults to the Frontend API origin when JWKSURL is its
+// /.well-known/jwks.json endpoint; otherwise iss is only checked if set.
+type ClerkAuthConfig struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	// AuthorizedParties lists the frontend origins allowed in the azp claim.
+	// Tokens without azp are accepted, matching Clerk's own SDKs.
+	AuthorizedParties  []string
+	Leeway             time.Duration
+	MinRefreshInterval time.Duration
 }
 
-type JWKS struct {
-	Keys []JWK `json:"keys"`
+type ClerkAuth struct {
+	verifier *JWKSVerifier
+	config   ClerkAuthConfig
 }
 
-type JWK struct {
-	Kid string `json:"kid"`
-	Kty string `json:"kty"`
-	Use string `json:"use"`
-	N   string `json:"n"`
-	E   string `json:"e"`
+func NewClerkAuth(config ClerkAuthConfig) (*ClerkAuth, error) {
+	if config.JWKSURL == "" {
+		return nil, fmt.Errorf("CLERK_JWKS_URL is required for Clerk admin authentication")
+	}
+	ca := newClerkAuth(config)
+	go ca.refreshKeys()
+	return ca, nil
 }
 
-func NewClerkAuth(jwksURL string) *ClerkAuth {
-	if jwksURL == "" {
-		jwksURL = "https://clerk.your-domain.com/.well-known/jwks.json"
+func newClerkAuth(config ClerkAuthConfig) *ClerkAuth {
+	if config.Issuer == "" && strings.HasSuffix(config.JWKSURL, "/.well-known/jwks.json") {
+		config.Issuer = strings.TrimSuffix(config.JWKSURL, "/.well-known/jwks.json")
 	}
-	ca := &ClerkAuth{
-		publicKeys: make(map[string]*rsa.PublicKey),
-		jwksURL:    jwksURL,
+	verifier := NewJWKSVerifier(config.JWKSURL)
+	if config.MinRefreshInterval > 0 {
+		verifier.minRefreshInterval = config.MinRefreshInterval
 	}
-	go ca.refreshKeys()
-	return ca
+	return &ClerkAuth{verifier: verifier, config: config}
 }
 
 func (ca *ClerkAuth) refreshKeys() {
 	ticker := time.NewTicker(1 * time.Hour)
 	defer ticker.Stop()
 
-	ca.fetchKeys()
+	ca.verifier.Refresh(context.Background())
 	for range ticker.C {
-		ca.fetchKeys()
+		ca.verifier.Refresh(context.Background())
 	}
 }
 
-func (ca *ClerkAuth) fetchKeys() error {
-	resp, err := http.Get(ca.jwksURL)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	var jwks JWKS
-	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-		return err
-	}
-
-	ca.mu.Lock()
-	defer ca.mu.Unlock()
-
-	for _, key := range jwks.Keys {
-		if key.Kty != "RSA" {
-			continue
-		}
-
-		nBytes, err := base64.RawURLEncoding.DecodeString(key.N)
-		if err != nil {
-			continue
-		}
-
-		eBytes, err := base64.RawURLEncoding.DecodeString(key.E)
-		if err != nil {
-			continue
-		}
-
-		var e int
-		for _, b := range eBytes {
-			e = e<<8 | int(b)
-		}
-
-		pubKey := &rsa.PublicKey{
-			N: new(big.Int).SetBytes(nBytes),
-			E: e,
-		}
-
-		ca.publicKeys[key.Kid] = pubKey
+func (ca *ClerkAuth) Middleware() func(http.Handler) http.Handler {
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(ca.config.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(ca.config.Leeway),
 	}
-
-	return nil
-}
-
-func (ca *ClerkAuth) getPublicKey(kid string) (*rsa.PublicKey, error) {
-	ca.mu.RLock()
-	key, exists := ca.publicKeys[kid]
-	ca.mu.RUnlock()
-
-	if !exists {
-		ca.fetchKeys()
-		ca.mu.RLock()
-		key, exists = ca.publicKeys[kid]
-		ca.mu.RUnlock()
-		if !exists {
-			return nil, fmt.Errorf("public key not found")
-		}
+	if ca.config.Audience != "" {
+		opts = append(opts, jwt.WithAudience(ca.config.Audience))
 	}
 
-	return key, nil
-}
-
-func (ca *ClerkAuth) Middleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authHeader := r.Header.Get("Authorization")
-			if authHeader == "" {
-				http.Error(w, `{"error":"missing authorization header"}`, http.StatusUnauthorized)
-				return
-			}
-
-			parts := strings.SplitN(authHeader, " ", 2)
-			if len(parts) != 2 || parts[0] != "Bearer" {
-				http.Error(w, `{"error":"invalid authorization format"}`, http.StatusUnauthorized)
+			token, ok := bearerToken(w, r)
+			if !ok {
 				return
 			}
 
-			tokenString := parts[1]
-
-			token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-				if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
-					return nil, fmt.Errorf("unexpected signing method")
-				}
-
-				kid, ok := token.Header["kid"].(string)
-				if !ok {
-					return nil, fmt.Errorf("kid header not found")
-				}
-
-				return ca.getPublicKey(kid)
-			})
-
-			if err != nil || !token.Valid {
+			claims, err := ca.verifier.Parse(r.Context(), token, opts...)
+			if err != nil {
 				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 				return
 			}
 
-			claims, ok := token.Claims.(jwt.MapClaims)
-			if !ok {
-				http.Error(w, `{"error":"invalid claims"}`, http.StatusUnauthorized)
+			if azp, ok := claims["azp"].(string); ok && len(ca.config.AuthorizedParties) > 0 && !containsString(ca.config.AuthorizedParties, azp) {
+				http.Error(w, `{"error":"unauthorized party"}`, http.StatusUnauthorized)
 				return
 			}
 
 			userID, ok := claims["sub"].(string)
-			if !ok {
+			if !ok || userID == "" {
 				http.Error(w, `{"error":"user ID not found in token"}`, http.StatusUnauthorized)
 				return
 			}
@@ -175,3 +102,12 @@ func (ca *ClerkAuth) Middleware() func(http.Handler) http.Handler {
 		})
 	}
 }
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,306 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testJWKS serves a mutable JWKS document and counts how often it is fetched.
+type testJWKS struct {
+	server *httptest.Server
+	hits   atomic.Int32
+	mu     sync.Mutex
+	keys   []JWK
+}
+
+func newTestJWKS(t *testing.T) *testJWKS {
+	t.Helper()
+	j := &testJWKS{}
+	j.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j.hits.Add(1)
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		json.NewEncoder(w).Encode(JWKS{Keys: j.keys})
+	}))
+	t.Cleanup(j.server.Close)
+	return j
+}
+
+func (j *testJWKS) setKeys(keys ...JWK) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.keys = keys
+}
+
+func (j *testJWKS) url() string {
+	return j.server.URL + "/.well-known/jwks.json"
+}
+
+func (j *testJWKS) issuer() string {
+	return j.server.URL
+}
+
+func rsaJWK(t *testing.T, kid string) (*rsa.PrivateKey, JWK) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, JWK{
+		Kid: kid,
+		Kty: "RSA",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(t *testing.T, kid string) (*ecdsa.PrivateKey, JWK) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, JWK{
+		Kid: kid,
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		Y:   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims(issuer string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub": "user_123",
+		"iss": issuer,
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// serve runs a request with the token through the middleware and returns the
+// status code and the user ID seen by the next handler.
+func serve(ca *ClerkAuth, token string) (int, string) {
+	var userID string
+	handler := ca.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = r.Context().Value(UserIDContextKey).(string)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/routes", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code, userID
+}
+
+func TestClerkAuthAcceptsRSAAndECTokens(t *testing.T) {
+	jwks := newTestJWKS(t)
+	rsaKey, rsaPub := rsaJWK(t, "rsa-1")
+	ecKey, ecPub := ecJWK(t, "ec-1")
+	jwks.setKeys(rsaPub, ecPub)
+
+	ca := newClerkAuth(ClerkAuthConfig{JWKSURL: jwks.url()})
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    crypto.PrivateKey
+	}{
+		{"RS256", jwt.SigningMethodRS256, "rsa-1", rsaKey},
+		{"ES256", jwt.SigningMethodES256, "ec-1", ecKey},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signToken(t, tt.method, tt.kid, tt.key, validClaims(jwks.issuer()))
+			code, userID := serve(ca, token)
+			if code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", code, http.StatusOK)
+			}
+			if userID != "user_123" {
+				t.Fatalf("user ID = %q, want %q", userID, "user_123")
+			}
+		})
+	}
+}
+
+func TestClerkAuthValidatesClaims(t *testing.T) {
+	jwks := newTestJWKS(t)
+	key, pub := rsaJWK(t, "rsa-1")
+	jwks.setKeys(pub)
+
+	ca := newClerkAuth(ClerkAuthConfig{
+		JWKSURL:           jwks.url(),
+		Audience:          "gateway",
+		AuthorizedParties: []string{"https://app.example.com"},
+		Leeway:            30 * time.Second,
+	})
+
+	tests := []struct {
+		name   string
+		mutate func(jwt.MapClaims)
+		want   int
+	}{
+		{"valid", func(c jwt.MapClaims) {}, http.StatusOK},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, http.StatusUnauthorized},
+		{"missing audience", func(c jwt.MapClaims) { delete(c, "aud") }, http.StatusUnauthorized},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "other" }, http.StatusUnauthorized},
+		{"unauthorized party", func(c jwt.MapClaims) { c["azp"] = "https://evil.example.com" }, http.StatusUnauthorized},
+		{"no azp", func(c jwt.MapClaims) { delete(c, "azp") }, http.StatusOK},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, http.StatusUnauthorized},
+		{"missing exp", func(c jwt.MapClaims) { delete(c, "exp") }, http.StatusUnauthorized},
+		{"nbf within leeway", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(10 * time.Second).Unix() }, http.StatusOK},
+		{"nbf beyond leeway", func(c jwt.MapClaims) { c["nbf"] = time.Now().Add(time.Minute).Unix() }, http.StatusUnauthorized},
+		{"missing sub", func(c jwt.MapClaims) { delete(c, "sub") }, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(jwks.issuer())
+			claims["aud"] = "gateway"
+			claims["azp"] = "https://app.example.com"
+			tt.mutate(claims)
+
+			code, _ := serve(ca, signToken(t, jwt.SigningMethodRS256, "rsa-1", key, claims))
+			if code != tt.want {
+				t.Fatalf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestClerkAuthRejectsSymmetricAlgorithms(t *testing.T) {
+	jwks := newTestJWKS(t)
+	_, pub := rsaJWK(t, "rsa-1")
+	jwks.setKeys(pub)
+
+	ca := newClerkAuth(ClerkAuthConfig{JWKSURL: jwks.url()})
+
+	// Classic algorithm confusion: sign with HS256 using the public modulus.
+	token := signToken(t, jwt.SigningMethodHS256, "rsa-1", []byte(pub.N), validClaims(jwks.issuer()))
+	if code, _ := serve(ca, token); code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestClerkAuthLimitsRefreshesForUnknownKids(t *testing.T) {
+	jwks := newTestJWKS(t)
+	key, pub := rsaJWK(t, "rsa-1")
+	jwks.setKeys(pub)
+
+	ca := newClerkAuth(ClerkAuthConfig{JWKSURL: jwks.url(), MinRefreshInterval: time.Hour})
+
+	if code, _ := serve(ca, signToken(t, jwt.SigningMethodRS256, "rsa-1", key, validClaims(jwks.issuer()))); code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", code, http.StatusOK)
+	}
+
+	for i := 0; i < 20; i++ {
+		token := signToken(t, jwt.SigningMethodRS256, "unknown", key, validClaims(jwks.issuer()))
+		if code, _ := serve(ca, token); code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+
+	if hits := jwks.hits.Load(); hits != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", hits)
+	}
+}
+
+func TestClerkAuthSharesConcurrentFetches(t *testing.T) {
+	jwks := newTestJWKS(t)
+	key, pub := rsaJWK(t, "rsa-1")
+	jwks.setKeys(pub)
+
+	ca := newClerkAuth(ClerkAuthConfig{JWKSURL: jwks.url(), MinRefreshInterval: time.Hour})
+	token := signToken(t, jwt.SigningMethodRS256, "rsa-1", key, validClaims(jwks.issuer()))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(ca, token)
+		}()
+	}
+	wg.Wait()
+
+	// Late arrivals may find the keys already loaded, but no request may
+	// trigger a second fetch inside the refresh interval.
+	if hits := jwks.hits.Load(); hits != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", hits)
+	}
+}
+
+func TestClerkAuthEvictsRotatedKeys(t *testing.T) {
+	jwks := newTestJWKS(t)
+	oldKey, oldPub := rsaJWK(t, "old")
+	newKey, newPub := rsaJWK(t, "new")
+	jwks.setKeys(oldPub)
+
+	ca := newClerkAuth(ClerkAuthConfig{JWKSURL: jwks.url(), MinRefreshInterval: time.Millisecond})
+
+	oldToken := signToken(t, jwt.SigningMethodRS256, "old", oldKey, validClaims(jwks.issuer()))
+	if code, _ := serve(ca, oldToken); code != http.StatusOK {
+		t.Fatalf("old key: status = %d, want %d", code, http.StatusOK)
+	}
+
+	jwks.setKeys(newPub)
+	time.Sleep(5 * time.Millisecond)
+
+	newToken := signToken(t, jwt.SigningMethodRS256, "new", newKey, validClaims(jwks.issuer()))
+	if code, _ := serve(ca, newToken); code != http.StatusOK {
+		t.Fatalf("new key: status = %d, want %d", code, http.StatusOK)
+	}
+	if code, _ := serve(ca, oldToken); code != http.StatusUnauthorized {
+		t.Fatalf("evicted key: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+}
+
+func TestJWKSVerifierBacksOffAfterFailures(t *testing.T) {
+	jwks := newTestJWKS(t)
+	key, _ := rsaJWK(t, "rsa-1")
+	jwks.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks.hits.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+
+	ca := newClerkAuth(ClerkAuthConfig{JWKSURL: jwks.url(), MinRefreshInterval: time.Hour})
+	token := signToken(t, jwt.SigningMethodRS256, "rsa-1", key, validClaims(jwks.issuer()))
+
+	for i := 0; i < 5; i++ {
+		if code, _ := serve(ca, token); code != http.StatusUnauthorized {
+			t.Fatalf("status = %d, want %d", code, http.StatusUnauthorized)
+		}
+	}
+	if hits := jwks.hits.Load(); hits != 1 {
+		t.Fatalf("JWKS fetched %d times, want 1", hits)
+	}
+}
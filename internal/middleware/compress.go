@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"gateway/internal/models"
+	"gateway/internal/services"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Compress encodes responses on routes with a compression policy, using the
+// best encoding the client accepts. Responses are compressed as they are
+// written, and flushes reach the client, so streamed bodies keep flowing.
+// A response flushed before MinBytes have been written is taken to be a
+// stream and compressed regardless of its size. HEAD responses get the
+// headers the GET response would have, without a body.
+func Compress() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, _ := r.Context().Value(RouteContextKey).(*models.Route)
+			if route == nil || route.Compression == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				config:         route.Compression,
+				encoding:       services.NegotiateEncoding(r.Header, route.Compression.Encodings),
+				head:           r.Method == http.MethodHead,
+			}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the response is worth compressing.
+type compressWriter struct {
+	http.ResponseWriter
+	config *models.CompressionConfig
+	// encoding is the negotiated coding, empty when the client accepts none.
+	encoding string
+	// head is set for HEAD requests, whose responses have no body to encode.
+	head bool
+
+	status      int
+	wroteHeader bool
+	// decided is set once the response is known to be compressed or not;
+	// until then the body is held in pending.
+	decided bool
+	pending []byte
+	enc     services.Encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+	cw.status = status
+
+	if !cw.eligible() {
+		cw.passThrough()
+		return
+	}
+	services.AddVary(cw.Header(), "Accept-Encoding")
+	if cw.encoding == "" {
+		cw.passThrough()
+		return
+	}
+	if cw.head {
+		// Without a body to go by, a response of unknown length is taken to
+		// be compressed, as a GET response flushed early would be.
+		cw.setEncodingHeaders()
+		cw.passThrough()
+		return
+	}
+	// Responses of known length were checked against MinBytes above; others
+	// are decided once enough of the body has been written.
+	if cw.Header().Get("Content-Length") != "" {
+		cw.startCompression()
+	}
+}
+
+// eligible reports whether the policy covers the response, going by its
+// status and headers.
+func (cw *compressWriter) eligible() bool {
+	switch {
+	case cw.status < http.StatusOK, cw.status == http.StatusNoContent,
+		cw.status == http.StatusPartialContent, cw.status == http.StatusNotModified:
+		return false
+	}
+	header := cw.Header()
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return false
+	}
+	if header.Get("Content-Range") != "" || services.ParseCacheControl(header).Has("no-transform") {
+		return false
+	}
+	if length, err := strconv.Atoi(header.Get("Content-Length")); err == nil && length < cw.config.MinBytes {
+		return false
+	}
+	return services.MatchMediaType(header.Get("Content-Type"), cw.config.ContentTypes)
+}
+
+func (cw *compressWriter) passThrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) startCompression() {
+	enc, ok := services.AcquireEncoder(cw.encoding, cw.ResponseWriter)
+	if !ok {
+		cw.passThrough()
+		return
+	}
+	cw.decided = true
+	cw.enc = enc
+	cw.setEncodingHeaders()
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) setEncodingHeaders() {
+	header := cw.Header()
+	header.Set("Content-Encoding", cw.encoding)
+	header.Del("Content-Length")
+	if etag := header.Get("ETag"); etag != "" {
+		header.Set("ETag", services.WeakETag(etag))
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.pending = append(cw.pending, b...)
+	if len(cw.pending) >= cw.config.MinBytes {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// decide settles a response whose length was unknown up front and writes
+// out the bytes held back so far.
+func (cw *compressWriter) decide(compress bool) error {
+	if compress {
+		cw.startCompression()
+	} else {
+		cw.passThrough()
+	}
+	pending := cw.pending
+	cw.pending = nil
+	if len(pending) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(pending)
+	} else {
+		_, err = cw.ResponseWriter.Write(pending)
+	}
+	return err
+}
+
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close finishes the response, sending anything still held back.
+func (cw *compressWriter) Close() error {
+	if !cw.wroteHeader {
+		// Nothing was written; net/http sends an empty 200.
+		return nil
+	}
+	var err error
+	if !cw.decided {
+		// Held back responses are shorter than MinBytes.
+		err = cw.decide(false)
+	}
+	if cw.enc != nil {
+		if closeErr := cw.enc.Close(); err == nil {
+			err = closeErr
+		}
+		services.ReleaseEncoder(cw.encoding, cw.enc)
+		cw.enc = nil
+	}
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"gateway/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompressHeadMirrorsGet(t *testing.T) {
+	route := &models.Route{Compression: &models.CompressionConfig{
+		Encodings:    []string{"gzip"},
+		MinBytes:     16,
+		ContentTypes: []string{"application/json"},
+	}}
+	body := `{"items":["` + strings.Repeat("a", 64) + `"]}`
+
+	tests := []struct {
+		name string
+		// length is sent as Content-Length when set.
+		length         bool
+		acceptEncoding string
+		wantEncoding   string
+	}{
+		{name: "known length", length: true, acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "unknown length", acceptEncoding: "gzip", wantEncoding: "gzip"},
+		{name: "client accepts no encoding", length: true, acceptEncoding: "identity"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Compress()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("ETag", `"v1"`)
+				if tt.length {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+				w.WriteHeader(http.StatusOK)
+				if r.Method != http.MethodHead {
+					w.Write([]byte(body))
+				}
+			}))
+
+			headers := map[string]http.Header{}
+			for _, method := range []string{http.MethodGet, http.MethodHead} {
+				r := httptest.NewRequest(method, "/items", nil)
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+				r = r.WithContext(context.WithValue(r.Context(), RouteContextKey, route))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, r)
+				headers[method] = rec.Header()
+				if method == http.MethodHead && rec.Body.Len() != 0 {
+					t.Fatalf("HEAD response has a %d byte body", rec.Body.Len())
+				}
+			}
+
+			for _, name := range []string{"Content-Encoding", "Vary", "ETag", "Content-Length"} {
+				get, head := headers[http.MethodGet].Get(name), headers[http.MethodHead].Get(name)
+				if get != head {
+					t.Fatalf("%s: GET = %q, HEAD = %q", name, get, head)
+				}
+			}
+			if got := headers[http.MethodHead].Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.wantEncoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"gateway/internal/analytics"
+	"gateway/internal/models"
+	"gateway/internal/services"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HMACAlgorithm       = "GW-HMAC-SHA256"
+	HMACKeyIDHeader     = "X-Gateway-Key-Id"
+	HMACTimestampHeader = "X-Gateway-Timestamp"
+	HMACNonceHeader     = "X-Gateway-Nonce"
+	HMACSignatureHeader = "X-Gateway-Signature"
+)
+
+// HMACAuth authenticates requests signed with an API key's signing secret.
+// The client sends the key ID, a Unix timestamp, a unique nonce and
+// hex(HMAC-SHA256(secret, StringToSign(...))) in the X-Gateway-* headers.
+// Requests outside clockSkew, or reusing a nonce, are rejected.
+func HMACAuth(apiKeyService *services.APIKeyService, nonces *services.NonceCache, analytics *analytics.Analytics, clockSkew time.Duration) func(http.Handler) http.Handler {
+	return hmacAuth(apiKeyService, nonces, analytics, clockSkew)
+}
+
+// signingKeyStore and nonceStore are what HMACAuth needs of APIKeyService
+// and NonceCache.
+type signingKeyStore interface {
+	GetSigningKey(ctx context.Context, id int64) (*models.APIKey, string, error)
+}
+
+type nonceStore interface {
+	Claim(ctx context.Context, scope, nonce string, ttl time.Duration) (bool, error)
+}
+
+func hmacAuth(keys signingKeyStore, nonces nonceStore, analytics *analytics.Analytics, clockSkew time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keyIDStr := r.Header.Get(HMACKeyIDHeader)
+			timestampStr := r.Header.Get(HMACTimestampHeader)
+			nonce := r.Header.Get(HMACNonceHeader)
+			signature := r.Header.Get(HMACSignatureHeader)
+			if keyIDStr == "" || timestampStr == "" || nonce == "" || signature == "" {
+				http.Error(w, `{"error":"missing request signature headers"}`, http.StatusUnauthorized)
+				return
+			}
+
+			keyID, err := strconv.ParseInt(keyIDStr, 10, 64)
+			if err != nil {
+				http.Error(w, `{"error":"invalid key ID"}`, http.StatusUnauthorized)
+				return
+			}
+
+			timestamp, err := strconv.ParseInt(timestampStr, 10, 64)
+			if err != nil {
+				http.Error(w, `{"error":"invalid timestamp"}`, http.StatusUnauthorized)
+				return
+			}
+			if skew := time.Since(time.Unix(timestamp, 0)); skew > clockSkew || skew < -clockSkew {
+				http.Error(w, `{"error":"request timestamp outside allowed window"}`, http.StatusUnauthorized)
+				return
+			}
+
+			apiKey, secret, err := keys.GetSigningKey(r.Context(), keyID)
+			if err != nil {
+				http.Error(w, `{"error":"invalid signature"}`, http.StatusUnauthorized)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				WriteBodyError(w, services.ErrBodyTooLarge)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error":"failed to read request body"}`, http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			expected := SignRequest(secret, StringToSign(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, timestampStr, nonce, body))
+			if !hmac.Equal([]byte(expected), []byte(strings.ToLower(signature))) {
+				http.Error(w, `{"error":"invalid signature"}`, http.StatusUnauthorized)
+				return
+			}
+
+			// Only claim the nonce once the signature checks out, so that
+			// unauthenticated callers cannot burn nonces of legitimate clients.
+			fresh, err := nonces.Claim(r.Context(), fmt.Sprintf("hmac:%d", apiKey.ID), nonce, 2*clockSkew)
+			if err != nil {
+				http.Error(w, `{"error":"signature verification failed"}`, http.StatusInternalServerError)
+				return
+			}
+			if !fresh {
+				http.Error(w, `{"error":"request nonce already used"}`, http.StatusUnauthorized)
+				return
+			}
+
+			if rejectRestricted(w, r, apiKey, analytics) {
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), APIKeyContextKey, apiKey)
+			r = r.Clone(ctx)
+			for _, header := range []string{HMACKeyIDHeader, HMACTimestampHeader, HMACNonceHeader, HMACSignatureHeader} {
+				r.Header.Del(header)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// StringToSign builds the canonical request that clients sign:
+//
+//	GW-HMAC-SHA256\n<timestamp>\n<nonce>\n<METHOD>\n<escaped path>\n<raw query>\n<hex sha256(body)>
+func StringToSign(method, escapedPath, rawQuery, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	return strings.Join([]string{
+		HMACAlgorithm,
+		timestamp,
+		nonce,
+		strings.ToUpper(method),
+		escapedPath,
+		rawQuery,
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+}
+
+func SignRequest(secret, stringToSign string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
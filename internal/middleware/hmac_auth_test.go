@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gateway/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testSigningKeyID  = 7
+	testSigningSecret = "test-signing-secret"
+	testClockSkew     = 5 * time.Minute
+)
+
+// testSigningKeys holds one API key with a signing secret.
+type testSigningKeys struct{}
+
+func (testSigningKeys) GetSigningKey(ctx context.Context, id int64) (*models.APIKey, string, error) {
+	if id != testSigningKeyID {
+		return nil, "", errors.New("no signing key")
+	}
+	return &models.APIKey{ID: id, Enabled: true}, testSigningSecret, nil
+}
+
+// testNonces is an in-memory nonce store.
+type testNonces struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (n *testNonces) Claim(ctx context.Context, scope, nonce string, ttl time.Duration) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.seen == nil {
+		n.seen = map[string]bool{}
+	}
+	key := scope + ":" + nonce
+	if n.seen[key] {
+		return false, nil
+	}
+	n.seen[key] = true
+	return true, nil
+}
+
+// signedRequest describes a request signed with secret.
+type signedRequest struct {
+	method    string
+	target    string
+	body      string
+	secret    string
+	timestamp time.Time
+	nonce     string
+	// sentBody replaces the body after signing when set.
+	sentBody *string
+}
+
+func (s signedRequest) build() *http.Request {
+	timestamp := strconv.FormatInt(s.timestamp.Unix(), 10)
+	r := httptest.NewRequest(s.method, s.target, strings.NewReader(s.body))
+	signature := SignRequest(s.secret, StringToSign(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, timestamp, s.nonce, []byte(s.body)))
+	if s.sentBody != nil {
+		r = httptest.NewRequest(s.method, s.target, strings.NewReader(*s.sentBody))
+	}
+	r.Header.Set(HMACKeyIDHeader, strconv.Itoa(testSigningKeyID))
+	r.Header.Set(HMACTimestampHeader, timestamp)
+	r.Header.Set(HMACNonceHeader, s.nonce)
+	r.Header.Set(HMACSignatureHeader, signature)
+	return r
+}
+
+func serveHMAC(handler http.Handler, r *http.Request) int {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	return rec.Code
+}
+
+func newHMACHandler(nonces *testNonces) http.Handler {
+	return hmacAuth(testSigningKeys{}, nonces, nil, testClockSkew)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value(APIKeyContextKey).(*models.APIKey); !ok {
+			http.Error(w, "no API key in context", http.StatusInternalServerError)
+			return
+		}
+		if r.Header.Get(HMACSignatureHeader) != "" {
+			http.Error(w, "signature headers forwarded", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestStringToSign(t *testing.T) {
+	got := StringToSign("post", "/orders/a%20b", "b=2&a=1", "1700000000", "n-1", []byte(`{"id":1}`))
+	want := strings.Join([]string{
+		HMACAlgorithm,
+		"1700000000",
+		"n-1",
+		"POST",
+		"/orders/a%20b",
+		"b=2&a=1",
+		"037c9214eef74cc3887f3a4f085b4e17d76280dafd273b0ee160c09c4ba1cfd4",
+	}, "\n")
+	if got != want {
+		t.Fatalf("StringToSign = %q, want %q", got, want)
+	}
+}
+
+func TestHMACAuthVerifiesRequests(t *testing.T) {
+	now := time.Now()
+	tampered := `{"amount":1000}`
+	tests := []struct {
+		name string
+		req  signedRequest
+		// edit changes the request after it was signed.
+		edit func(r *http.Request)
+		want int
+	}{
+		{
+			name: "valid",
+			req:  signedRequest{method: "POST", target: "/orders?b=2&a=1", body: `{"amount":10}`, secret: testSigningSecret, timestamp: now},
+			want: http.StatusOK,
+		},
+		{
+			name: "valid without body",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now},
+			want: http.StatusOK,
+		},
+		{
+			name: "wrong secret",
+			req:  signedRequest{method: "POST", target: "/orders", body: `{"amount":10}`, secret: "other-secret", timestamp: now},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "body changed after signing",
+			req:  signedRequest{method: "POST", target: "/orders", body: `{"amount":10}`, secret: testSigningSecret, timestamp: now, sentBody: &tampered},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "query changed after signing",
+			req:  signedRequest{method: "GET", target: "/orders?page=1", secret: testSigningSecret, timestamp: now},
+			edit: func(r *http.Request) { r.URL.RawQuery = "page=2" },
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "method changed after signing",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now},
+			edit: func(r *http.Request) { r.Method = http.MethodDelete },
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "uppercase signature",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now},
+			edit: func(r *http.Request) {
+				r.Header.Set(HMACSignatureHeader, strings.ToUpper(r.Header.Get(HMACSignatureHeader)))
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "unknown key",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now},
+			edit: func(r *http.Request) { r.Header.Set(HMACKeyIDHeader, "8") },
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "missing signature",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now},
+			edit: func(r *http.Request) { r.Header.Del(HMACSignatureHeader) },
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp inside skew",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now.Add(-testClockSkew + time.Minute)},
+			want: http.StatusOK,
+		},
+		{
+			name: "timestamp too old",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now.Add(-testClockSkew - time.Minute)},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp too far ahead",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now.Add(testClockSkew + time.Minute)},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "timestamp not a number",
+			req:  signedRequest{method: "GET", target: "/orders", secret: testSigningSecret, timestamp: now},
+			edit: func(r *http.Request) { r.Header.Set(HMACTimestampHeader, "yesterday") },
+			want: http.StatusUnauthorized,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.nonce = fmt.Sprintf("nonce-%d", i)
+			r := tt.req.build()
+			if tt.edit != nil {
+				tt.edit(r)
+			}
+			if code := serveHMAC(newHMACHandler(&testNonces{}), r); code != tt.want {
+				t.Fatalf("status = %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHMACAuthRejectsReplayedNonces(t *testing.T) {
+	handler := newHMACHandler(&testNonces{})
+	req := signedRequest{method: "POST", target: "/orders", body: `{"amount":10}`, secret: testSigningSecret, timestamp: time.Now(), nonce: "once"}
+
+	if code := serveHMAC(handler, req.build()); code != http.StatusOK {
+		t.Fatalf("first request: status = %d, want %d", code, http.StatusOK)
+	}
+	if code := serveHMAC(handler, req.build()); code != http.StatusUnauthorized {
+		t.Fatalf("replayed request: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	// A fresh nonce is still accepted.
+	req.nonce = "twice"
+	if code := serveHMAC(handler, req.build()); code != http.StatusOK {
+		t.Fatalf("new nonce: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestHMACAuthDoesNotBurnNoncesOnBadSignatures(t *testing.T) {
+	handler := newHMACHandler(&testNonces{})
+	forged := signedRequest{method: "GET", target: "/orders", secret: "guessed-secret", timestamp: time.Now(), nonce: "shared"}
+	if code := serveHMAC(handler, forged.build()); code != http.StatusUnauthorized {
+		t.Fatalf("forged request: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	genuine := forged
+	genuine.secret = testSigningSecret
+	if code := serveHMAC(handler, genuine.build()); code != http.StatusOK {
+		t.Fatalf("genuine request with the same nonce: status = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// SupportedJWTAlgorithms lists the asymmetric algorithms accepted by
+// JWKSVerifier. Symmetric algorithms are never accepted, so a JWKS public key
+// cannot be abused as an HMAC secret.
+var SupportedJWTAlgorithms = []string{
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+type JWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+const (
+	defaultMinJWKSRefreshInterval = 5 * time.Minute
+	maxJWKSRefreshBackoff         = time.Hour
+)
+
+// JWKSVerifier verifies JWTs against the signing keys published at a JWKS
+// URL. Keys are fetched lazily and refreshed when an unknown kid is seen, but
+// at most once per minRefreshInterval (longer after failures) so that tokens
+// with made-up kids cannot be used to make the gateway hammer the issuer.
+type JWKSVerifier struct {
+	jwksURL string
+	client  *http.Client
+
+	keys map[string]crypto.PublicKey
+	mu   sync.RWMutex
+
+	fetches            singleflight.Group
+	minRefreshInterval time.Duration
+	nextRefresh        time.Time
+	failures           int
+	refreshMu          sync.Mutex
+}
+
+func NewJWKSVerifier(jwksURL string) *JWKSVerifier {
+	return &JWKSVerifier{
+		jwksURL:            jwksURL,
+		client:             &http.Client{Timeout: 10 * time.Second},
+		keys:               make(map[string]crypto.PublicKey),
+		minRefreshInterval: defaultMinJWKSRefreshInterval,
+	}
+}
+
+// Parse verifies the token signature and standard time claims, applying any
+// additional parser options such as issuer or audience checks.
+func (v *JWKSVerifier) Parse(ctx context.Context, tokenString string, opts ...jwt.ParserOption) (jwt.MapClaims, error) {
+	opts = append([]jwt.ParserOption{jwt.WithValidMethods(SupportedJWTAlgorithms)}, opts...)
+
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return v.keyfunc(ctx, token)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid claims")
+	}
+	return claims, nil
+}
+
+func (v *JWKSVerifier) keyfunc(ctx context.Context, token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, fmt.Errorf("kid header not found")
+	}
+
+	key, err := v.publicKey(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey:
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			return key, nil
+		}
+	case *ecdsa.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodECDSA); ok {
+			return key, nil
+		}
+	case ed25519.PublicKey:
+		if _, ok := token.Method.(*jwt.SigningMethodEd25519); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("signing method %s does not match key type", token.Method.Alg())
+}
+
+func (v *JWKSVerifier) publicKey(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.RLock()
+	key, exists := v.keys[kid]
+	v.mu.RUnlock()
+
+	if !exists {
+		if err := v.refresh(ctx, false); err != nil {
+			return nil, err
+		}
+		v.mu.RLock()
+		key, exists = v.keys[kid]
+		v.mu.RUnlock()
+		if !exists {
+			return nil, fmt.Errorf("public key not found")
+		}
+	}
+
+	return key, nil
+}
+
+// Refresh fetches the JWKS and replaces the key set, so keys that are no
+// longer published stop being accepted. Concurrent callers share one fetch.
+func (v *JWKSVerifier) Refresh(ctx context.Context) error {
+	return v.refresh(ctx, true)
+}
+
+// refresh fetches the JWKS unless force is false and the last fetch was too
+// recent, in which case the current key set is kept.
+func (v *JWKSVerifier) refresh(ctx context.Context, force bool) error {
+	_, err, _ := v.fetches.Do(v.jwksURL, func() (interface{}, error) {
+		v.refreshMu.Lock()
+		throttled := !force && time.Now().Before(v.nextRefresh)
+		v.refreshMu.Unlock()
+		if throttled {
+			return nil, nil
+		}
+
+		// The fetch is shared, so it must not fail because the request that
+		// happened to start it went away.
+		keys, err := v.fetch(context.WithoutCancel(ctx))
+
+		v.refreshMu.Lock()
+		if err != nil {
+			v.failures++
+			backoff := v.minRefreshInterval << (v.failures - 1)
+			if backoff <= 0 || backoff > maxJWKSRefreshBackoff {
+				backoff = maxJWKSRefreshBackoff
+			}
+			v.nextRefresh = time.Now().Add(backoff)
+		} else {
+			v.failures = 0
+			v.nextRefresh = time.Now().Add(v.minRefreshInterval)
+		}
+		v.refreshMu.Unlock()
+
+		if err != nil {
+			return nil, err
+		}
+
+		v.mu.Lock()
+		v.keys = keys
+		v.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+func (v *JWKSVerifier) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected JWKS status %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+// PublicKey decodes an RSA, EC (P-256/384/521) or OKP (Ed25519) JWK.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		var e int
+		for _, b := range eBytes {
+			e = e<<8 | int(b)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(nBytes),
+			E: e,
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		yBytes, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(xBytes),
+			Y:     new(big.Int).SetBytes(yBytes),
+		}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		xBytes, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(xBytes) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 key size")
+		}
+		return ed25519.PublicKey(xBytes), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// JWKSCache shares one verifier per JWKS URL across routes.
+type JWKSCache struct {
+	verifiers map[string]*JWKSVerifier
+	mu        sync.Mutex
+}
+
+func NewJWKSCache() *JWKSCache {
+	return &JWKSCache{verifiers: make(map[string]*JWKSVerifier)}
+}
+
+func (c *JWKSCache) Get(jwksURL string) *JWKSVerifier {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	verifier, ok := c.verifiers[jwksURL]
+	if !ok {
+		verifier = NewJWKSVerifier(jwksURL)
+		c.verifiers[jwksURL] = verifier
+	}
+	return verifier
+}
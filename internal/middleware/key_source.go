@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"errors"
+	"gateway/internal/models"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	errMissingAPIKey     = errors.New("missing API key")
+	errInvalidAuthFormat = errors.New("invalid authorization format")
+)
+
+// KeySource describes where a request carries its API key. Name is the
+// header, query parameter or cookie name and is unused for bearer tokens.
+type KeySource struct {
+	Location string
+	Name     string
+}
+
+// NewKeySource fills in the conventional name for a location when none is
+// configured.
+func NewKeySource(location, name string) KeySource {
+	if location == "" {
+		location = models.KeyLocationBearer
+	}
+	if name == "" {
+		switch location {
+		case models.KeyLocationHeader:
+			name = "X-API-Key"
+		case models.KeyLocationQuery, models.KeyLocationCookie:
+			name = "api_key"
+		}
+	}
+	return KeySource{Location: location, Name: name}
+}
+
+func routeKeySource(route *models.Route, fallback KeySource) KeySource {
+	if route == nil || route.KeyLocation == "" {
+		return fallback
+	}
+	return NewKeySource(route.KeyLocation, route.KeyName)
+}
+
+func (s KeySource) extract(r *http.Request) (string, error) {
+	switch s.Location {
+	case models.KeyLocationHeader:
+		if key := r.Header.Get(s.Name); key != "" {
+			return key, nil
+		}
+	case models.KeyLocationQuery:
+		if key := r.URL.Query().Get(s.Name); key != "" {
+			return key, nil
+		}
+	case models.KeyLocationCookie:
+		if cookie, err := r.Cookie(s.Name); err == nil && cookie.Value != "" {
+			return cookie.Value, nil
+		}
+	default:
+		authHeader := r.Header.Get("Authorization")
+		if authHeader == "" {
+			return "", errMissingAPIKey
+		}
+		parts := strings.SplitN(authHeader, " ", 2)
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", errInvalidAuthFormat
+		}
+		return parts[1], nil
+	}
+	return "", errMissingAPIKey
+}
+
+// strip removes the API key from the request so it is never forwarded to a
+// backend. The request must be a clone owned by the caller.
+func (s KeySource) strip(r *http.Request) {
+	switch s.Location {
+	case models.KeyLocationHeader:
+		r.Header.Del(s.Name)
+	case models.KeyLocationQuery:
+		r.URL.RawQuery = removeQueryParam(r.URL.RawQuery, s.Name)
+	case models.KeyLocationCookie:
+		cookies := r.Cookies()
+		r.Header.Del("Cookie")
+		for _, cookie := range cookies {
+			if cookie.Name != s.Name {
+				r.AddCookie(cookie)
+			}
+		}
+	default:
+		r.Header.Del("Authorization")
+	}
+}
+
+// removeQueryParam drops every occurrence of name while keeping the order and
+// encoding of the remaining parameters untouched.
+func removeQueryParam(rawQuery, name string) string {
+	if rawQuery == "" {
+		return rawQuery
+	}
+	kept := make([]string, 0)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			continue
+		}
+		kept = append(kept, pair)
+	}
+	return strings.Join(kept, "&")
+}
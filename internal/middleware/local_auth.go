@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway/internal/models"
+	"gateway/internal/services"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AdminSessionCookie = "gateway_admin_session"
+	CSRFTokenHeader    = "X-CSRF-Token"
+
+	loginAttemptsPerMinute = 10
+)
+
+// LocalAuth authenticates admins against bcrypt password hashes from
+// configuration and keeps them logged in with a session cookie. Requests
+// other than GET, HEAD and OPTIONS must echo the session's CSRF token in the
+// X-CSRF-Token header.
+type LocalAuth struct {
+	users        map[string][]byte
+	sessions     *services.AdminSessionStore
+	rateLimiter  *services.RateLimiter
+	secureCookie bool
+	// dummyHash is compared against for unknown users so that login timing
+	// does not reveal which usernames exist.
+	dummyHash []byte
+}
+
+// NewLocalAuth takes a map of username to bcrypt hash. The username is used
+// as the admin user ID.
+func NewLocalAuth(users map[string]string, sessions *services.AdminSessionStore, rateLimiter *services.RateLimiter, secureCookie bool) (*LocalAuth, error) {
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no admin users configured")
+	}
+
+	a := &LocalAuth{
+		users:        make(map[string][]byte, len(users)),
+		sessions:     sessions,
+		rateLimiter:  rateLimiter,
+		secureCookie: secureCookie,
+	}
+	for username, hash := range users {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash for admin user %q: %w", username, err)
+		}
+		a.users[username] = []byte(hash)
+	}
+
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, err
+	}
+	dummyHash, err := bcrypt.GenerateFromPassword(password, bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	a.dummyHash = dummyHash
+
+	return a, nil
+}
+
+// Login checks a username and password, sets the session cookie and returns
+// the CSRF token for the session.
+func (a *LocalAuth) Login(w http.ResponseWriter, r *http.Request) {
+	ipAddr := r.RemoteAddr
+	if clientIP := ClientIP(r); clientIP != nil {
+		ipAddr = clientIP.String()
+	}
+	allowed, err := a.rateLimiter.Allow(r.Context(), "admin_login:"+ipAddr, loginAttemptsPerMinute)
+	if err != nil {
+		http.Error(w, `{"error":"login unavailable"}`, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, `{"error":"too many login attempts"}`, http.StatusTooManyRequests)
+		return
+	}
+
+	var req models.AdminLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `{"error":"invalid request body"}`, http.StatusBadRequest)
+		return
+	}
+
+	hash, ok := a.users[req.Username]
+	if !ok {
+		hash = a.dummyHash
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(req.Password)); err != nil || !ok {
+		http.Error(w, `{"error":"invalid username or password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	token, session, err := a.sessions.Create(r.Context(), req.Username)
+	if err != nil {
+		http.Error(w, `{"error":"failed to create session"}`, http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, a.cookie(token, int(a.sessions.TTL().Seconds())))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"user_id":    session.UserID,
+		"csrf_token": session.CSRFToken,
+	})
+}
+
+// Logout ends the current session, if any, and clears the cookie.
+func (a *LocalAuth) Logout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(AdminSessionCookie); err == nil {
+		if err := a.sessions.Delete(r.Context(), cookie.Value); err != nil {
+			http.Error(w, `{"error":"failed to end session"}`, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.SetCookie(w, a.cookie("", -1))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *LocalAuth) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie(AdminSessionCookie)
+			if err != nil || cookie.Value == "" {
+				http.Error(w, `{"error":"not logged in"}`, http.StatusUnauthorized)
+				return
+			}
+
+			session, err := a.sessions.Get(r.Context(), cookie.Value)
+			if errors.Is(err, services.ErrSessionNotFound) {
+				http.Error(w, `{"error":"session expired"}`, http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error":"failed to load session"}`, http.StatusInternalServerError)
+				return
+			}
+
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				csrfToken := r.Header.Get(CSRFTokenHeader)
+				if csrfToken == "" || subtle.ConstantTimeCompare([]byte(csrfToken), []byte(session.CSRFToken)) != 1 {
+					http.Error(w, `{"error":"invalid CSRF token"}`, http.StatusForbidden)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, session.UserID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func (a *LocalAuth) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     AdminSessionCookie,
+		Value:    value,
+		Path:     "/admin",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   a.secureCookie,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
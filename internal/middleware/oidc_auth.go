@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const oidcDiscoveryRetryInterval = time.Minute
+
+// OIDCAuthConfig describes a generic OpenID Connect provider. The JWKS URL
+// and canonical issuer are read from the provider's discovery document.
+type OIDCAuthConfig struct {
+	IssuerURL string
+	// ClientID is required in the aud claim when set.
+	ClientID string
+	// UserClaim names the claim used as the admin user ID; defaults to sub.
+	UserClaim string
+	Leeway    time.Duration
+}
+
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// OIDCAuth validates ID or access tokens issued by an OIDC provider, such as
+// Keycloak or Dex, for the admin API. Discovery happens on first use so the
+// gateway can start while the provider is unreachable.
+type OIDCAuth struct {
+	config OIDCAuthConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	issuer        string
+	verifier      *JWKSVerifier
+	nextDiscovery time.Time
+}
+
+func NewOIDCAuth(config OIDCAuthConfig) (*OIDCAuth, error) {
+	u, err := url.Parse(config.IssuerURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("OIDC_ISSUER_URL must be an http(s) URL")
+	}
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+
+	return &OIDCAuth{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// discover returns the verifier for the provider's JWKS, fetching the
+// discovery document if it has not been loaded yet. Failed attempts are
+// retried at most once per oidcDiscoveryRetryInterval.
+func (a *OIDCAuth) discover(ctx context.Context) (*JWKSVerifier, string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.verifier != nil {
+		return a.verifier, a.issuer, nil
+	}
+	if time.Now().Before(a.nextDiscovery) {
+		return nil, "", fmt.Errorf("OIDC discovery unavailable")
+	}
+
+	doc, err := a.fetchDiscovery(ctx)
+	if err != nil {
+		a.nextDiscovery = time.Now().Add(oidcDiscoveryRetryInterval)
+		return nil, "", err
+	}
+
+	a.issuer = doc.Issuer
+	a.verifier = NewJWKSVerifier(doc.JWKSURI)
+	return a.verifier, a.issuer, nil
+}
+
+func (a *OIDCAuth) fetchDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	discoveryURL := strings.TrimSuffix(a.config.IssuerURL, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodGet, discoveryURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected OIDC discovery status %d", resp.StatusCode)
+	}
+
+	var doc oidcDiscovery
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode OIDC discovery document: %w", err)
+	}
+
+	// The discovery document must describe the issuer we were configured
+	// with, otherwise anyone able to serve it could pick the signing keys.
+	if strings.TrimSuffix(doc.Issuer, "/") != strings.TrimSuffix(a.config.IssuerURL, "/") {
+		return nil, fmt.Errorf("OIDC discovery issuer %q does not match %q", doc.Issuer, a.config.IssuerURL)
+	}
+	if u, err := url.Parse(doc.JWKSURI); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("OIDC discovery document has an invalid jwks_uri")
+	}
+	return &doc, nil
+}
+
+func (a *OIDCAuth) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(w, r)
+			if !ok {
+				return
+			}
+
+			verifier, issuer, err := a.discover(r.Context())
+			if err != nil {
+				http.Error(w, `{"error":"identity provider unavailable"}`, http.StatusServiceUnavailable)
+				return
+			}
+
+			opts := []jwt.ParserOption{
+				jwt.WithIssuer(issuer),
+				jwt.WithExpirationRequired(),
+				jwt.WithLeeway(a.config.Leeway),
+			}
+			if a.config.ClientID != "" {
+				opts = append(opts, jwt.WithAudience(a.config.ClientID))
+			}
+
+			claims, err := verifier.Parse(r.Context(), token, opts...)
+			if err != nil {
+				http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
+				return
+			}
+
+			userID, ok := claims[a.config.UserClaim].(string)
+			if !ok || userID == "" {
+				http.Error(w, `{"error":"user ID not found in token"}`, http.StatusUnauthorized)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"gateway/internal/services"
+	"net/http"
+	"strconv"
+)
+
+const (
+	MembershipContextKey contextKey = "membership"
+	OrgIDHeader                     = "X-Org-ID"
+)
+
+// OrgContext resolves the organization an admin request acts on: the one in
+// the X-Org-ID header, or the caller's personal organization when the header
+// is absent. The caller's membership is stored under MembershipContextKey.
+func OrgContext(orgService *services.OrgService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(UserIDContextKey).(string)
+			if !ok || userID == "" {
+				http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+
+			orgIDStr := r.Header.Get(OrgIDHeader)
+			if orgIDStr == "" {
+				member, err := orgService.EnsurePersonalOrg(r.Context(), userID)
+				if err != nil {
+					http.Error(w, `{"error":"failed to load organization"}`, http.StatusInternalServerError)
+					return
+				}
+				ctx := context.WithValue(r.Context(), MembershipContextKey, member)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
+			orgID, err := strconv.ParseInt(orgIDStr, 10, 64)
+			if err != nil {
+				http.Error(w, `{"error":"invalid organization ID"}`, http.StatusBadRequest)
+				return
+			}
+
+			member, err := orgService.GetMembership(r.Context(), orgID, userID)
+			if errors.Is(err, services.ErrNotMember) {
+				http.Error(w, `{"error":"not a member of this organization"}`, http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, `{"error":"failed to load organization"}`, http.StatusInternalServerError)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), MembershipContextKey, member)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -7,24 +7,43 @@ import (
 	"net/http"
 )
 
+// defaultConsumerRateLimitRPM applies to JWT-authenticated consumers on
+// routes that do not set jwt_config.rate_limit_rpm.
+const defaultConsumerRateLimitRPM = 60
+
+// APIKeyRateLimitKey is the rate limiter counter used for an API key.
+func APIKeyRateLimitKey(apiKey *models.APIKey) string {
+	return fmt.Sprintf("apikey:%d", apiKey.ID)
+}
+
 func RateLimiting(limiter *services.RateLimiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			apiKey, ok := r.Context().Value(APIKeyContextKey).(*models.APIKey)
-			if !ok {
+			var key string
+			var limit int
+
+			if apiKey, ok := r.Context().Value(APIKeyContextKey).(*models.APIKey); ok {
+				key = APIKeyRateLimitKey(apiKey)
+				limit = apiKey.RateLimitRPM
+			} else if consumer, ok := r.Context().Value(ConsumerContextKey).(*Consumer); ok {
+				key = fmt.Sprintf("jwt:%s:%s", consumer.Issuer, consumer.Subject)
+				limit = defaultConsumerRateLimitRPM
+				if route, ok := r.Context().Value(RouteContextKey).(*models.Route); ok && route.JWTConfig != nil && route.JWTConfig.RateLimitRPM > 0 {
+					limit = route.JWTConfig.RateLimitRPM
+				}
+			} else {
 				http.Error(w, `{"error":"missing API key in context"}`, http.StatusInternalServerError)
 				return
 			}
 
-			key := fmt.Sprintf("apikey:%d", apiKey.ID)
-			allowed, err := limiter.Allow(r.Context(), key, apiKey.RateLimitRPM)
+			allowed, err := limiter.Allow(r.Context(), key, limit)
 			if err != nil {
 				http.Error(w, `{"error":"rate limit check failed"}`, http.StatusInternalServerError)
 				return
 			}
 
 			if !allowed {
-				w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", apiKey.RateLimitRPM))
+				w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
 				http.Error(w, `{"error":"rate limit exceeded"}`, http.StatusTooManyRequests)
 				return
 			}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"gateway/internal/models"
+	"gateway/internal/services"
+	"net/http"
+)
+
+const RouteContextKey contextKey = "route"
+
+// ResolveRoute looks up the route for the request path so that later
+// middleware can apply per-route settings. Unknown paths are passed through
+// without a route; the proxy handler answers them with 404.
+func ResolveRoute(routeService *services.RouteService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, err := routeService.Resolve(r.Context(), r.URL.Path)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), RouteContextKey, route)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RouteAuth dispatches each request to the authenticator selected by its
+// route's auth_mode. Requests without a route, or with an unknown mode, use
+// the API key authenticator.
+func RouteAuth(authenticators map[string]func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		handlers := make(map[string]http.Handler, len(authenticators))
+		for mode, authenticate := range authenticators {
+			handlers[mode] = authenticate(next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mode := models.AuthModeAPIKey
+			if route, ok := r.Context().Value(RouteContextKey).(*models.Route); ok && route.AuthMode != "" {
+				mode = route.AuthMode
+			}
+
+			handler, ok := handlers[mode]
+			if !ok {
+				handler = handlers[models.AuthModeAPIKey]
+			}
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LimitRequestBody caps request bodies at the route's max_body_bytes, or
+// defaultMax for routes without one, before anything reads them. Requests
+// declaring a larger Content-Length are rejected outright; larger bodies
+// sent without one fail when read with an *http.MaxBytesError.
+func LimitRequestBody(defaultMax int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route, _ := r.Context().Value(RouteContextKey).(*models.Route)
+			limit := services.RequestBodyLimit(route, defaultMax)
+			if limit > 0 {
+				if r.ContentLength > limit {
+					WriteBodyError(w, services.ErrBodyTooLarge)
+					return
+				}
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func WriteBodyError(w http.ResponseWriter, err *services.BodyError) {
+	http.Error(w, fmt.Sprintf(`{"error":%q,"reason":%q}`, err.Message, err.Reason), err.StatusCode)
+}
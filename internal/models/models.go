@@ -1,38 +1,201 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
+const (
+	KeyLocationBearer = "bearer"
+	KeyLocationHeader = "header"
+	KeyLocationQuery  = "query"
+	KeyLocationCookie = "cookie"
+)
+
+const (
+	AuthModeAPIKey = "api_key"
+	AuthModeHMAC   = "hmac"
+	AuthModeJWT    = "jwt"
+)
+
+// Organization member roles, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+type Organization struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Personal  bool      `json:"personal"`
+	Role      string    `json:"role,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Membership is a user's role in an organization. Admin requests act as a
+// membership, which services check before touching the org's resources.
+type Membership struct {
+	OrgID     int64     `json:"org_id"`
+	UserID    string    `json:"user_id"`
+	Role      string    `json:"role"`
+	Personal  bool      `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 type Route struct {
-	ID                     int64     `json:"id"`
-	Path                   string    `json:"path"`
-	BackendURLs            []string  `json:"backend_urls"`
-	LoadBalancingStrategy  string    `json:"load_balancing_strategy"`
-	TimeoutMs              int       `json:"timeout_ms"`
-	RetryCount             int       `json:"retry_count"`
-	UserID                 string    `json:"user_id"`
-	CreatedAt              time.Time `json:"created_at"`
+	ID                    int64      `json:"id"`
+	Path                  string     `json:"path"`
+	BackendURLs           []string   `json:"backend_urls"`
+	LoadBalancingStrategy string     `json:"load_balancing_strategy"`
+	TimeoutMs             int        `json:"timeout_ms"`
+	RetryCount            int        `json:"retry_count"`
+	SharedWithOrgIDs      []int64    `json:"shared_with_org_ids"`
+	KeyLocation           string     `json:"key_location"`
+	KeyName               string     `json:"key_name"`
+	AuthMode              string     `json:"auth_mode"`
+	JWTConfig             *JWTConfig `json:"jwt_config,omitempty"`
+	// Compression enables compressing responses; nil leaves them as the
+	// backend sent them.
+	Compression *CompressionConfig `json:"compression,omitempty"`
+	// Request bodies larger than MaxBodyBytes (zero uses the gateway
+	// default) are rejected, as are bodies whose type is not one of
+	// AllowedContentTypes, when set, and invalid JSON with ValidateJSON.
+	MaxBodyBytes        int64     `json:"max_body_bytes"`
+	AllowedContentTypes []string  `json:"allowed_content_types"`
+	ValidateJSON        bool      `json:"validate_json"`
+	OrgID               int64     `json:"org_id"`
+	UserID              string    `json:"user_id"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// JWTConfig configures validation of end-user JWTs on routes with
+// auth_mode "jwt".
+type JWTConfig struct {
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	JWKSURL  string `json:"jwks_url"`
+	// RequiredClaims maps claim names to the value they must have. For
+	// array claims, the value must be one of the elements.
+	RequiredClaims map[string]string `json:"required_claims"`
+	// ForwardClaims maps claim names to the request header used to pass
+	// them on to the backend, e.g. {"sub": "X-User-ID"}.
+	ForwardClaims map[string]string `json:"forward_claims"`
+	RateLimitRPM  int               `json:"rate_limit_rpm"`
+}
+
+// CompressionConfig is a route's response compression policy.
+type CompressionConfig struct {
+	// Encodings the gateway may use (br, zstd, gzip), most preferred first.
+	// Clients' Accept-Encoding weights take precedence over the order.
+	Encodings []string `json:"encodings"`
+	// Responses smaller than MinBytes are sent uncompressed.
+	MinBytes int `json:"min_bytes"`
+	// ContentTypes lists the media types to compress, such as
+	// "application/json" or "text/*".
+	ContentTypes []string `json:"content_types"`
 }
 
 type APIKey struct {
-	ID           int64     `json:"id"`
-	Key          string    `json:"key"`
-	Name         string    `json:"name"`
-	Tier         string    `json:"tier"`
-	RateLimitRPM int       `json:"rate_limit_rpm"`
-	Enabled      bool      `json:"enabled"`
-	UserID       string    `json:"user_id"`
-	CreatedAt    time.Time `json:"created_at"`
+	ID               int64     `json:"id"`
+	Key              string    `json:"key"`
+	Name             string    `json:"name"`
+	Tier             string    `json:"tier"`
+	RateLimitRPM     int       `json:"rate_limit_rpm"`
+	Enabled          bool      `json:"enabled"`
+	AllowedRouteIDs  []int64   `json:"allowed_route_ids"`
+	AllowedPaths     []string  `json:"allowed_paths"`
+	AllowedMethods   []string  `json:"allowed_methods"`
+	AllowedCIDRs     []string  `json:"allowed_cidrs"`
+	AllowedReferrers []string  `json:"allowed_referrers"`
+	CachePurge       bool      `json:"cache_purge"`
+	OrgID            int64     `json:"org_id"`
+	UserID           string    `json:"user_id"`
+	CreatedAt        time.Time `json:"created_at"`
+	// Usage counters, updated when analytics events are flushed.
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	LastUsedIP   string     `json:"last_used_ip"`
+	RequestCount int64      `json:"request_count"`
 }
 
 type CacheRule struct {
-	ID              int64  `json:"id"`
-	RouteID         int64  `json:"route_id"`
-	TTLSeconds      int    `json:"ttl_seconds"`
-	CacheKeyPattern string `json:"cache_key_pattern"`
-	Enabled         bool   `json:"enabled"`
-	UserID          string `json:"user_id"`
+	ID         int64 `json:"id"`
+	RouteID    int64 `json:"route_id"`
+	TTLSeconds int   `json:"ttl_seconds"`
+	// How long past ttl_seconds an entry may be served while it is refreshed
+	// in the background, or when the backend fails.
+	StaleWhileRevalidateSeconds int    `json:"stale_while_revalidate_seconds"`
+	StaleIfErrorSeconds         int    `json:"stale_if_error_seconds"`
+	CacheKeyPattern             string `json:"cache_key_pattern"`
+	// Methods the rule caches: GET, and optionally HEAD and POST.
+	Methods []string `json:"methods"`
+	// Responses with these statuses are cached for NegativeTTLSeconds;
+	// zero disables negative caching.
+	NegativeTTLSeconds  int   `json:"negative_ttl_seconds"`
+	NegativeStatusCodes []int `json:"negative_status_codes"`
+	// Largest body, as stored, that is cached; zero uses the gateway default.
+	MaxEntryBytes int64  `json:"max_entry_bytes"`
+	Enabled       bool   `json:"enabled"`
+	OrgID         int64  `json:"org_id"`
+	UserID        string `json:"user_id"`
+}
+
+// CacheStats describes what a route has in the cache, across all tenants.
+type CacheStats struct {
+	RouteID int64 `json:"route_id"`
+	Keys    int64 `json:"keys"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// CacheEntry describes a cached resource, without the response bodies.
+// OrgID is the tenant the entry was stored for.
+type CacheEntry struct {
+	Key     string `json:"key"`
+	OrgID   int64  `json:"org_id"`
+	RouteID int64  `json:"route_id"`
+	// Request headers the representations vary on.
+	Vary []string `json:"vary"`
+	// Size is the stored bytes of all representations.
+	Size int64 `json:"size"`
+	// ExpiresInSeconds is how long until the cache drops the resource.
+	ExpiresInSeconds int64          `json:"expires_in_seconds"`
+	Variants         []CacheVariant `json:"variants"`
+}
+
+// CacheVariant is one stored representation of a cached resource.
+type CacheVariant struct {
+	// Request is the method and URI that stored it, when known.
+	Request     string    `json:"request,omitempty"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type,omitempty"`
+	Encoding    string    `json:"encoding,omitempty"`
+	Size        int64     `json:"size"`
+	StoredAt    time.Time `json:"stored_at"`
+	AgeSeconds  int64     `json:"age_seconds"`
+	// TTLRemainingSeconds is how long it stays fresh; zero once stale.
+	TTLRemainingSeconds int64    `json:"ttl_remaining_seconds"`
+	Tags                []string `json:"tags,omitempty"`
+}
+
+type CacheEntryPage struct {
+	Entries    []*CacheEntry `json:"entries"`
+	NextCursor *uint64       `json:"next_cursor"`
+}
+
+// WarmCacheRequest replays GET requests for paths through the proxy so that
+// their responses are cached. Refresh fetches them even if already cached.
+type WarmCacheRequest struct {
+	Paths   []string          `json:"paths"`
+	Headers map[string]string `json:"headers"`
+	Refresh bool              `json:"refresh"`
+}
+
+type WarmCacheResult struct {
+	Path       string `json:"path"`
+	StatusCode int    `json:"status_code"`
+	Cache      string `json:"cache"`
+	Error      string `json:"error,omitempty"`
 }
 
 type AnalyticsEvent struct {
@@ -40,49 +203,175 @@ type AnalyticsEvent struct {
 	Timestamp time.Time `json:"timestamp"`
 	RouteID   *int64    `json:"route_id"`
 	APIKeyID  *int64    `json:"api_key_id"`
+	OrgID     *int64    `json:"org_id"`
 	UserID    string    `json:"user_id"`
 	StatusCode int      `json:"status_code"`
 	LatencyMs int64     `json:"latency_ms"`
 	CacheHit  bool      `json:"cache_hit"`
+	CacheStale bool     `json:"cache_stale"`
+	CacheTier string    `json:"cache_tier,omitempty"`
 	IPAddress string    `json:"ip_address"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// AuditEntry records one admin mutation. Changes holds the modified fields
+// as {"before": {...}, "after": {...}}, with secrets redacted.
+type AuditEntry struct {
+	ID           int64           `json:"id"`
+	Timestamp    time.Time       `json:"timestamp"`
+	OrgID        *int64          `json:"org_id"`
+	ActorUserID  string          `json:"actor_user_id"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resource_type"`
+	ResourceID   string          `json:"resource_id"`
+	Changes      json.RawMessage `json:"changes"`
+	RequestID    string          `json:"request_id"`
+	IPAddress    string          `json:"ip_address"`
+	PrevHash     string          `json:"prev_hash"`
+	Hash         string          `json:"hash"`
+}
+
+type AuditFilter struct {
+	ActorUserID  string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	Since        *time.Time
+	Until        *time.Time
+	// BeforeID continues a listing from the previous page's next_cursor.
+	BeforeID int64
+	Limit    int
+}
+
+type AuditPage struct {
+	Entries    []*AuditEntry `json:"entries"`
+	NextCursor *int64        `json:"next_cursor"`
+}
+
+type AuditVerification struct {
+	Valid          bool   `json:"valid"`
+	Checked        int64  `json:"checked"`
+	FirstInvalidID *int64 `json:"first_invalid_id,omitempty"`
 }
 
 type CreateRouteRequest struct {
-	Path                   string   `json:"path"`
-	BackendURLs            []string `json:"backend_urls"`
-	LoadBalancingStrategy  string   `json:"load_balancing_strategy"`
-	TimeoutMs              int      `json:"timeout_ms"`
-	RetryCount             int      `json:"retry_count"`
+	Path                  string             `json:"path"`
+	BackendURLs           []string           `json:"backend_urls"`
+	LoadBalancingStrategy string             `json:"load_balancing_strategy"`
+	TimeoutMs             int                `json:"timeout_ms"`
+	RetryCount            int                `json:"retry_count"`
+	SharedWithOrgIDs      []int64            `json:"shared_with_org_ids"`
+	KeyLocation           string             `json:"key_location"`
+	KeyName               string             `json:"key_name"`
+	AuthMode              string             `json:"auth_mode"`
+	JWTConfig             *JWTConfig         `json:"jwt_config,omitempty"`
+	Compression           *CompressionConfig `json:"compression,omitempty"`
+	MaxBodyBytes          int64              `json:"max_body_bytes"`
+	AllowedContentTypes   []string           `json:"allowed_content_types"`
+	ValidateJSON          bool               `json:"validate_json"`
 }
 
 type UpdateRouteRequest struct {
-	BackendURLs            []string `json:"backend_urls"`
-	LoadBalancingStrategy  string   `json:"load_balancing_strategy"`
-	TimeoutMs              int      `json:"timeout_ms"`
-	RetryCount             int      `json:"retry_count"`
+	BackendURLs           []string           `json:"backend_urls"`
+	LoadBalancingStrategy string             `json:"load_balancing_strategy"`
+	TimeoutMs             int                `json:"timeout_ms"`
+	RetryCount            int                `json:"retry_count"`
+	SharedWithOrgIDs      []int64            `json:"shared_with_org_ids"`
+	KeyLocation           string             `json:"key_location"`
+	KeyName               string             `json:"key_name"`
+	AuthMode              string             `json:"auth_mode"`
+	JWTConfig             *JWTConfig         `json:"jwt_config,omitempty"`
+	Compression           *CompressionConfig `json:"compression,omitempty"`
+	MaxBodyBytes          int64              `json:"max_body_bytes"`
+	AllowedContentTypes   []string           `json:"allowed_content_types"`
+	ValidateJSON          bool               `json:"validate_json"`
 }
 
 type CreateAPIKeyRequest struct {
-	Name         string `json:"name"`
-	Tier         string `json:"tier"`
-	RateLimitRPM int    `json:"rate_limit_rpm"`
+	Name            string   `json:"name"`
+	Tier            string   `json:"tier"`
+	RateLimitRPM    int      `json:"rate_limit_rpm"`
+	AllowedRouteIDs []int64  `json:"allowed_route_ids"`
+	AllowedPaths    []string `json:"allowed_paths"`
+	AllowedMethods  []string `json:"allowed_methods"`
+	CachePurge      bool     `json:"cache_purge"`
+}
+
+type UpdateAPIKeyScopesRequest struct {
+	AllowedRouteIDs []int64  `json:"allowed_route_ids"`
+	AllowedPaths    []string `json:"allowed_paths"`
+	AllowedMethods  []string `json:"allowed_methods"`
+	CachePurge      bool     `json:"cache_purge"`
+}
+
+// PurgeCacheRequest purges cached responses carrying any of the tags.
+type PurgeCacheRequest struct {
+	Tags []string `json:"tags"`
+}
+
+type APIKeyRestrictions struct {
+	AllowedCIDRs     []string `json:"allowed_cidrs"`
+	AllowedReferrers []string `json:"allowed_referrers"`
+}
+
+type CreateOrganizationRequest struct {
+	Name string `json:"name"`
+}
+
+type AddMemberRequest struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type AdminLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type CreateCacheRuleRequest struct {
-	RouteID         int64  `json:"route_id"`
-	TTLSeconds      int    `json:"ttl_seconds"`
-	CacheKeyPattern string `json:"cache_key_pattern"`
+	RouteID                     int64    `json:"route_id"`
+	TTLSeconds                  int      `json:"ttl_seconds"`
+	StaleWhileRevalidateSeconds int      `json:"stale_while_revalidate_seconds"`
+	StaleIfErrorSeconds         int      `json:"stale_if_error_seconds"`
+	CacheKeyPattern             string   `json:"cache_key_pattern"`
+	Methods                     []string `json:"methods"`
+	NegativeTTLSeconds          int      `json:"negative_ttl_seconds"`
+	NegativeStatusCodes         []int    `json:"negative_status_codes"`
+	MaxEntryBytes               int64    `json:"max_entry_bytes"`
+}
+
+// UpdateCacheRuleRequest replaces a rule's settings. An empty
+// cache_key_pattern keeps the current one.
+type UpdateCacheRuleRequest struct {
+	TTLSeconds                  int      `json:"ttl_seconds"`
+	StaleWhileRevalidateSeconds int      `json:"stale_while_revalidate_seconds"`
+	StaleIfErrorSeconds         int      `json:"stale_if_error_seconds"`
+	Enabled                     bool     `json:"enabled"`
+	CacheKeyPattern             string   `json:"cache_key_pattern"`
+	Methods                     []string `json:"methods"`
+	NegativeTTLSeconds          int      `json:"negative_ttl_seconds"`
+	NegativeStatusCodes         []int    `json:"negative_status_codes"`
+	MaxEntryBytes               int64    `json:"max_entry_bytes"`
 }
 
 type AnalyticsMetrics struct {
 	TotalRequests  int64              `json:"total_requests"`
 	ErrorRate      float64            `json:"error_rate"`
 	CacheHitRatio  float64            `json:"cache_hit_ratio"`
+	StaleServes    int64              `json:"stale_serves"`
 	LatencyP50     int64              `json:"latency_p50"`
 	LatencyP95     int64              `json:"latency_p95"`
 	LatencyP99     int64              `json:"latency_p99"`
 	RequestsPerMin []RequestsPerMin   `json:"requests_per_min"`
 	TopEndpoints   []EndpointStats    `json:"top_endpoints"`
+	// Share of all requests served by each cache tier; they add up to
+	// CacheHitRatio.
+	LocalCacheHitRatio float64 `json:"local_cache_hit_ratio"`
+	RedisCacheHitRatio float64 `json:"redis_cache_hit_ratio"`
 }
 
 type RequestsPerMin struct {
@@ -90,6 +379,51 @@ type RequestsPerMin struct {
 	Count     int64     `json:"count"`
 }
 
+// APIKeyUsage holds a key's lifetime counters and its traffic per interval
+// over the requested window.
+type APIKeyUsage struct {
+	APIKeyID     int64         `json:"api_key_id"`
+	RequestCount int64         `json:"request_count"`
+	LastUsedAt   *time.Time    `json:"last_used_at"`
+	LastUsedIP   string        `json:"last_used_ip"`
+	Interval     string        `json:"interval"`
+	Series       []UsageBucket `json:"series"`
+}
+
+type UsageBucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	Requests  int64     `json:"requests"`
+	Errors    int64     `json:"errors"`
+	// Requests refused by the key's scopes or restrictions.
+	Rejected int64 `json:"rejected"`
+}
+
+// ConsumerStatus is what an API key holder sees at /_gateway/me.
+type ConsumerStatus struct {
+	KeyID        int64           `json:"key_id"`
+	Name         string          `json:"name"`
+	Tier         string          `json:"tier"`
+	RateLimit    RateLimitStatus `json:"rate_limit"`
+	RequestCount int64           `json:"request_count"`
+	LastUsedAt   *time.Time      `json:"last_used_at"`
+	LastHour     UsageSummary    `json:"last_hour"`
+	Last24Hours  UsageSummary    `json:"last_24_hours"`
+}
+
+type RateLimitStatus struct {
+	Limit     int       `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetAt   time.Time `json:"reset_at"`
+}
+
+type UsageSummary struct {
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	Rejected  int64   `json:"rejected"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
 type EndpointStats struct {
 	Path          string  `json:"path"`
 	RequestCount  int64   `json:"request_count"`
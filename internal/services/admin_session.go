@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// AdminSession is a logged-in local admin. CSRFToken must accompany every
+// state-changing request made with the session cookie.
+type AdminSession struct {
+	UserID    string
+	CSRFToken string
+}
+
+// AdminSessionStore keeps local admin sessions in Redis. Only a hash of the
+// session token is stored, so a Redis dump cannot be used to log in.
+type AdminSessionStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewAdminSessionStore(client *redis.Client, ttl time.Duration) *AdminSessionStore {
+	return &AdminSessionStore{client: client, ttl: ttl}
+}
+
+func (s *AdminSessionStore) TTL() time.Duration {
+	return s.ttl
+}
+
+// Create starts a session and returns its token, which is only known to the
+// caller.
+func (s *AdminSessionStore) Create(ctx context.Context, userID string) (string, *AdminSession, error) {
+	token, err := generateSessionToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	csrfToken, err := generateSessionToken()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate CSRF token: %w", err)
+	}
+
+	key := sessionKey(token)
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "csrf_token", csrfToken)
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return token, &AdminSession{UserID: userID, CSRFToken: csrfToken}, nil
+}
+
+func (s *AdminSessionStore) Get(ctx context.Context, token string) (*AdminSession, error) {
+	values, err := s.client.HGetAll(ctx, sessionKey(token)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if values["user_id"] == "" {
+		return nil, ErrSessionNotFound
+	}
+	return &AdminSession{UserID: values["user_id"], CSRFToken: values["csrf_token"]}, nil
+}
+
+func (s *AdminSessionStore) Delete(ctx context.Context, token string) error {
+	if err := s.client.Del(ctx, sessionKey(token)).Err(); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func sessionKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return "admin_session:" + hex.EncodeToString(hash[:])
+}
+
+func generateSessionToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,121 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"gateway/internal/models"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+var ErrInvalidRestriction = errors.New("invalid API key restriction")
+
+var (
+	ErrIPNotAllowed       = &AccessError{Reason: "ip_not_allowed", Message: "client IP is not allowed for this API key"}
+	ErrReferrerNotAllowed = &AccessError{Reason: "referrer_not_allowed", Message: "origin is not allowed for this API key"}
+)
+
+// CheckRestrictions enforces the network and browser restrictions of an API
+// key. origin is taken from the Origin header, falling back to Referer.
+func CheckRestrictions(apiKey *models.APIKey, clientIP net.IP, origin, referer string) *AccessError {
+	if len(apiKey.AllowedCIDRs) > 0 && !ipAllowed(apiKey.AllowedCIDRs, clientIP) {
+		return ErrIPNotAllowed
+	}
+
+	if len(apiKey.AllowedReferrers) > 0 {
+		if origin == "" || origin == "null" {
+			origin = refererOrigin(referer)
+		}
+		if !referrerAllowed(apiKey.AllowedReferrers, strings.ToLower(origin)) {
+			return ErrReferrerNotAllowed
+		}
+	}
+
+	return nil
+}
+
+func ipAllowed(cidrs []string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// referrerAllowed matches an origin ("https://app.example.com") against
+// patterns such as "https://*.example.com" or "*.example.com". Patterns
+// without a scheme match the host only.
+func referrerAllowed(patterns []string, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	host := origin
+	if u, err := url.Parse(origin); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	for _, pattern := range patterns {
+		target := host
+		if strings.Contains(pattern, "://") {
+			target = origin
+		}
+		if matched, err := path.Match(pattern, target); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func refererOrigin(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// normalizeCIDRs validates CIDRs and widens bare addresses to single-host
+// networks so they can be stored and matched uniformly.
+func normalizeCIDRs(cidrs []string) ([]string, error) {
+	normalized := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q is not an IP address or CIDR", ErrInvalidRestriction, cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q is not a valid CIDR", ErrInvalidRestriction, cidr)
+		}
+		normalized = append(normalized, network.String())
+	}
+	return normalized, nil
+}
+
+func normalizeReferrers(referrers []string) []string {
+	normalized := make([]string, 0, len(referrers))
+	for _, referrer := range referrers {
+		referrer = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(referrer), "/"))
+		if referrer != "" {
+			normalized = append(normalized, referrer)
+		}
+	}
+	return normalized
+}
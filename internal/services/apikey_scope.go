@@ -0,0 +1,98 @@
+package services
+
+import (
+	"gateway/internal/models"
+	"path"
+	"strings"
+)
+
+// AccessError is returned when an API key is valid but may not be used for
+// a request. Reason is a stable code recorded with the analytics event.
+type AccessError struct {
+	Reason  string
+	Message string
+}
+
+func (e *AccessError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrRouteNotShared   = &AccessError{Reason: "route_not_shared", Message: "route is not owned by or shared with the API key's organization"}
+	ErrRouteOutOfScope  = &AccessError{Reason: "route_out_of_scope", Message: "route is outside the API key scope"}
+	ErrPathOutOfScope   = &AccessError{Reason: "path_out_of_scope", Message: "path is outside the API key scope"}
+	ErrMethodOutOfScope = &AccessError{Reason: "method_out_of_scope", Message: "method is not allowed for this API key"}
+)
+
+// AuthorizeRoute reports whether apiKey may call route with the given method
+// and request path. Empty scope lists impose no restriction, but ownership is
+// always enforced.
+func AuthorizeRoute(apiKey *models.APIKey, route *models.Route, method, requestPath string) *AccessError {
+	if !routeAccessible(route, apiKey.OrgID) {
+		return ErrRouteNotShared
+	}
+
+	if len(apiKey.AllowedRouteIDs) > 0 {
+		allowed := false
+		for _, id := range apiKey.AllowedRouteIDs {
+			if id == route.ID {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrRouteOutOfScope
+		}
+	}
+
+	if len(apiKey.AllowedPaths) > 0 {
+		allowed := false
+		for _, pattern := range apiKey.AllowedPaths {
+			if MatchPathPattern(pattern, requestPath) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return ErrPathOutOfScope
+		}
+	}
+
+	if len(apiKey.AllowedMethods) > 0 && !containsString(apiKey.AllowedMethods, strings.ToUpper(method)) {
+		return ErrMethodOutOfScope
+	}
+
+	return nil
+}
+
+func routeAccessible(route *models.Route, orgID int64) bool {
+	if route.OrgID == orgID {
+		return true
+	}
+	for _, id := range route.SharedWithOrgIDs {
+		if id == orgID {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchPathPattern matches a request path against a scope pattern. A trailing
+// "/*" matches everything below that prefix, including nested segments;
+// otherwise the pattern uses path.Match semantics.
+func MatchPathPattern(pattern, requestPath string) bool {
+	if strings.HasSuffix(pattern, "/*") || pattern == "*" {
+		return strings.HasPrefix(requestPath, strings.TrimSuffix(pattern, "*"))
+	}
+	matched, err := path.Match(pattern, requestPath)
+	return err == nil && matched
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
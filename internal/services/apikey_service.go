@@ -4,12 +4,17 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"gateway/internal/models"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const apiKeyColumns = `id, key, name, tier, rate_limit_rpm, enabled, allowed_route_ids, allowed_paths, allowed_methods, allowed_cidrs, allowed_referrers, cache_purge, org_id, user_id, created_at, last_used_at, last_used_ip, request_count`
+
 type APIKeyService struct {
 	db *pgxpool.Pool
 }
@@ -18,7 +23,20 @@ func NewAPIKeyService(db *pgxpool.Pool) *APIKeyService {
 	return &APIKeyService{db: db}
 }
 
-func (s *APIKeyService) Create(ctx context.Context, userID string, req *models.CreateAPIKeyRequest) (*models.APIKey, error) {
+func scanAPIKey(row pgx.Row) (*models.APIKey, error) {
+	apiKey := &models.APIKey{}
+	err := row.Scan(&apiKey.ID, &apiKey.Key, &apiKey.Name, &apiKey.Tier, &apiKey.RateLimitRPM, &apiKey.Enabled, &apiKey.AllowedRouteIDs, &apiKey.AllowedPaths, &apiKey.AllowedMethods, &apiKey.AllowedCIDRs, &apiKey.AllowedReferrers, &apiKey.CachePurge, &apiKey.OrgID, &apiKey.UserID, &apiKey.CreatedAt, &apiKey.LastUsedAt, &apiKey.LastUsedIP, &apiKey.RequestCount)
+	if err != nil {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func (s *APIKeyService) Create(ctx context.Context, actor *models.Membership, req *models.CreateAPIKeyRequest, audit Auditor) (*models.APIKey, error) {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
 	key, err := generateAPIKey()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate API key: %w", err)
@@ -28,30 +46,37 @@ func (s *APIKeyService) Create(ctx context.Context, userID string, req *models.C
 		req.Tier = "free"
 	}
 
-	apiKey := &models.APIKey{}
-	err = s.db.QueryRow(
-		ctx,
-		`INSERT INTO api_keys (key, name, tier, rate_limit_rpm, enabled, user_id)
-		 VALUES ($1, $2, $3, $4, $5, $6)
-		 RETURNING id, key, name, tier, rate_limit_rpm, enabled, user_id, created_at`,
-		key, req.Name, req.Tier, req.RateLimitRPM, true, userID,
-	).Scan(&apiKey.ID, &apiKey.Key, &apiKey.Name, &apiKey.Tier, &apiKey.RateLimitRPM, &apiKey.Enabled, &apiKey.UserID, &apiKey.CreatedAt)
+	routeIDs, paths, methods := normalizeScopes(req.AllowedRouteIDs, req.AllowedPaths, req.AllowedMethods)
 
+	var apiKey *models.APIKey
+	err = withTx(ctx, s.db, func(tx pgx.Tx) error {
+		var err error
+		apiKey, err = scanAPIKey(tx.QueryRow(
+			ctx,
+			`INSERT INTO api_keys (key, name, tier, rate_limit_rpm, enabled, allowed_route_ids, allowed_paths, allowed_methods, cache_purge, org_id, user_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			 RETURNING `+apiKeyColumns,
+			key, req.Name, req.Tier, req.RateLimitRPM, true, routeIDs, paths, methods, req.CachePurge, actor.OrgID, actor.UserID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to create API key: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, apiKey.ID, nil, apiKey)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create API key: %w", err)
+		return nil, err
 	}
 
 	return apiKey, nil
 }
 
 func (s *APIKeyService) GetByKey(ctx context.Context, key string) (*models.APIKey, error) {
-	apiKey := &models.APIKey{}
-	err := s.db.QueryRow(
+	apiKey, err := scanAPIKey(s.db.QueryRow(
 		ctx,
-		`SELECT id, key, name, tier, rate_limit_rpm, enabled, user_id, created_at
+		`SELECT `+apiKeyColumns+`
 		 FROM api_keys WHERE key = $1 AND enabled = true`,
 		key,
-	).Scan(&apiKey.ID, &apiKey.Key, &apiKey.Name, &apiKey.Tier, &apiKey.RateLimitRPM, &apiKey.Enabled, &apiKey.UserID, &apiKey.CreatedAt)
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get API key: %w", err)
@@ -60,12 +85,16 @@ func (s *APIKeyService) GetByKey(ctx context.Context, key string) (*models.APIKe
 	return apiKey, nil
 }
 
-func (s *APIKeyService) List(ctx context.Context, userID string) ([]*models.APIKey, error) {
+func (s *APIKeyService) List(ctx context.Context, actor *models.Membership) ([]*models.APIKey, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
 	rows, err := s.db.Query(
 		ctx,
-		`SELECT id, key, name, tier, rate_limit_rpm, enabled, user_id, created_at
-		 FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`,
-		userID,
+		`SELECT `+apiKeyColumns+`
+		 FROM api_keys WHERE org_id = $1 ORDER BY created_at DESC`,
+		actor.OrgID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list API keys: %w", err)
@@ -74,36 +103,249 @@ func (s *APIKeyService) List(ctx context.Context, userID string) ([]*models.APIK
 
 	keys := []*models.APIKey{}
 	for rows.Next() {
-		key := &models.APIKey{}
-		if err := rows.Scan(&key.ID, &key.Key, &key.Name, &key.Tier, &key.RateLimitRPM, &key.Enabled, &key.UserID, &key.CreatedAt); err != nil {
+		key, err := scanAPIKey(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan API key: %w", err)
 		}
-		keys = append(keys, key)
+		keys = append(keys, maskAPIKey(actor, key))
 	}
 
 	return keys, nil
 }
 
-func (s *APIKeyService) Revoke(ctx context.Context, userID string, id int64) error {
-	result, err := s.db.Exec(ctx, `UPDATE api_keys SET enabled = false WHERE id = $1 AND user_id = $2`, id, userID)
+func (s *APIKeyService) GetByID(ctx context.Context, actor *models.Membership, id int64) (*models.APIKey, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	apiKey, err := scanAPIKey(s.db.QueryRow(
+		ctx,
+		`SELECT `+apiKeyColumns+`
+		 FROM api_keys WHERE id = $1 AND org_id = $2`,
+		id, actor.OrgID,
+	))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	return maskAPIKey(actor, apiKey), nil
+}
+
+func (s *APIKeyService) UpdateScopes(ctx context.Context, actor *models.Membership, id int64, req *models.UpdateAPIKeyScopesRequest, audit Auditor) (*models.APIKey, error) {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	routeIDs, paths, methods := normalizeScopes(req.AllowedRouteIDs, req.AllowedPaths, req.AllowedMethods)
+
+	var apiKey *models.APIKey
+	err := withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := s.lockAPIKey(ctx, tx, actor, id)
+		if err != nil {
+			return fmt.Errorf("failed to update API key scopes: %w", err)
+		}
+
+		apiKey, err = scanAPIKey(tx.QueryRow(
+			ctx,
+			`UPDATE api_keys
+			 SET allowed_route_ids = $1, allowed_paths = $2, allowed_methods = $3, cache_purge = $4
+			 WHERE id = $5 AND org_id = $6
+			 RETURNING `+apiKeyColumns,
+			routeIDs, paths, methods, req.CachePurge, id, actor.OrgID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to update API key scopes: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, apiKey)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+func (s *APIKeyService) GetRestrictions(ctx context.Context, actor *models.Membership, id int64) (*models.APIKeyRestrictions, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	restrictions := &models.APIKeyRestrictions{}
+	err := s.db.QueryRow(
+		ctx,
+		`SELECT allowed_cidrs, allowed_referrers FROM api_keys WHERE id = $1 AND org_id = $2`,
+		id, actor.OrgID,
+	).Scan(&restrictions.AllowedCIDRs, &restrictions.AllowedReferrers)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API key restrictions: %w", err)
+	}
+
+	return restrictions, nil
+}
+
+func (s *APIKeyService) UpdateRestrictions(ctx context.Context, actor *models.Membership, id int64, req *models.APIKeyRestrictions, audit Auditor) (*models.APIKeyRestrictions, error) {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	cidrs, err := normalizeCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	referrers := normalizeReferrers(req.AllowedReferrers)
+
+	restrictions := &models.APIKeyRestrictions{}
+	err = withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := s.lockAPIKey(ctx, tx, actor, id)
+		if err != nil {
+			return fmt.Errorf("failed to update API key restrictions: %w", err)
+		}
+
+		err = tx.QueryRow(
+			ctx,
+			`UPDATE api_keys SET allowed_cidrs = $1, allowed_referrers = $2
+			 WHERE id = $3 AND org_id = $4
+			 RETURNING allowed_cidrs, allowed_referrers`,
+			cidrs, referrers, id, actor.OrgID,
+		).Scan(&restrictions.AllowedCIDRs, &restrictions.AllowedReferrers)
+		if err != nil {
+			return fmt.Errorf("failed to update API key restrictions: %w", err)
+		}
+		previous := &models.APIKeyRestrictions{AllowedCIDRs: before.AllowedCIDRs, AllowedReferrers: before.AllowedReferrers}
+		return audit(ctx, tx, actor.OrgID, id, previous, restrictions)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restrictions, nil
+}
+
+// GenerateSigningSecret creates a new HMAC signing secret for the key,
+// replacing any previous one. The secret is only returned here; it cannot be
+// read back through the API afterwards.
+func (s *APIKeyService) GenerateSigningSecret(ctx context.Context, actor *models.Membership, id int64, audit Auditor) (string, error) {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return "", err
+	}
+
+	secret, err := generateSigningSecret()
 	if err != nil {
-		return fmt.Errorf("failed to revoke API key: %w", err)
+		return "", fmt.Errorf("failed to generate signing secret: %w", err)
 	}
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("API key not found or access denied")
+
+	err = withTx(ctx, s.db, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `UPDATE api_keys SET signing_secret = $1 WHERE id = $2 AND org_id = $3`, secret, id, actor.OrgID)
+		if err != nil {
+			return fmt.Errorf("failed to store signing secret: %w", err)
+		}
+		if result.RowsAffected() == 0 {
+			return fmt.Errorf("API key not found or access denied")
+		}
+		return audit(ctx, tx, actor.OrgID, id, nil, map[string]string{"signing_secret": secret})
+	})
+	if err != nil {
+		return "", err
 	}
-	return nil
+
+	return secret, nil
 }
 
-func (s *APIKeyService) Delete(ctx context.Context, userID string, id int64) error {
-	result, err := s.db.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+// GetSigningKey loads an enabled API key together with its signing secret for
+// verifying an HMAC-signed request.
+func (s *APIKeyService) GetSigningKey(ctx context.Context, id int64) (*models.APIKey, string, error) {
+	var secret string
+	apiKey := &models.APIKey{}
+	err := s.db.QueryRow(
+		ctx,
+		`SELECT `+apiKeyColumns+`, signing_secret
+		 FROM api_keys WHERE id = $1 AND enabled = true AND signing_secret IS NOT NULL`,
+		id,
+	).Scan(&apiKey.ID, &apiKey.Key, &apiKey.Name, &apiKey.Tier, &apiKey.RateLimitRPM, &apiKey.Enabled, &apiKey.AllowedRouteIDs, &apiKey.AllowedPaths, &apiKey.AllowedMethods, &apiKey.AllowedCIDRs, &apiKey.AllowedReferrers, &apiKey.CachePurge, &apiKey.OrgID, &apiKey.UserID, &apiKey.CreatedAt, &apiKey.LastUsedAt, &apiKey.LastUsedIP, &apiKey.RequestCount, &secret)
+
 	if err != nil {
-		return fmt.Errorf("failed to delete API key: %w", err)
+		return nil, "", fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	return apiKey, secret, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, actor *models.Membership, id int64, audit Auditor) error {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return err
+	}
+
+	return withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := s.lockAPIKey(ctx, tx, actor, id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("API key not found or access denied")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to revoke API key: %w", err)
+		}
+
+		after, err := scanAPIKey(tx.QueryRow(
+			ctx,
+			`UPDATE api_keys SET enabled = false WHERE id = $1 AND org_id = $2
+			 RETURNING `+apiKeyColumns,
+			id, actor.OrgID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to revoke API key: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, after)
+	})
+}
+
+func (s *APIKeyService) Delete(ctx context.Context, actor *models.Membership, id int64, audit Auditor) error {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return err
 	}
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("API key not found or access denied")
+
+	return withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := scanAPIKey(tx.QueryRow(
+			ctx,
+			`DELETE FROM api_keys WHERE id = $1 AND org_id = $2
+			 RETURNING `+apiKeyColumns,
+			id, actor.OrgID,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("API key not found or access denied")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete API key: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, nil)
+	})
+}
+
+// lockAPIKey reads a key of the actor's organization and locks it for the
+// rest of the transaction, so it is the state the change is applied to.
+func (s *APIKeyService) lockAPIKey(ctx context.Context, tx pgx.Tx, actor *models.Membership, id int64) (*models.APIKey, error) {
+	return scanAPIKey(tx.QueryRow(
+		ctx,
+		`SELECT `+apiKeyColumns+`
+		 FROM api_keys WHERE id = $1 AND org_id = $2 FOR UPDATE`,
+		id, actor.OrgID,
+	))
+}
+
+// maskedKeyLength is how much of a key members below admin see: the "gw_"
+// prefix and four characters, enough to tell keys apart.
+const maskedKeyLength = 7
+
+// maskAPIKey hides the key from members below admin. Anyone holding it can
+// call the organization's routes, so only admins may read it back.
+func maskAPIKey(actor *models.Membership, apiKey *models.APIKey) *models.APIKey {
+	if Authorize(actor, models.RoleAdmin) == nil {
+		return apiKey
 	}
-	return nil
+	if len(apiKey.Key) > maskedKeyLength {
+		apiKey.Key = apiKey.Key[:maskedKeyLength] + "..."
+	}
+	return apiKey
 }
 
 func generateAPIKey() (string, error) {
@@ -113,3 +355,29 @@ func generateAPIKey() (string, error) {
 	}
 	return "gw_" + base64.URLEncoding.EncodeToString(b)[:43], nil
 }
+
+func generateSigningSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gws_" + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// normalizeScopes turns nil scope lists into empty ones (the columns are NOT
+// NULL) and upper-cases methods so they compare directly against r.Method.
+func normalizeScopes(routeIDs []int64, paths, methods []string) ([]int64, []string, []string) {
+	if routeIDs == nil {
+		routeIDs = []int64{}
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+	normalized := make([]string, 0, len(methods))
+	for _, m := range methods {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			normalized = append(normalized, m)
+		}
+	}
+	return routeIDs, paths, normalized
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gateway/internal/models"
+	"hash"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	auditGenesisHash     = "0000000000000000000000000000000000000000000000000000000000000000"
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+const auditColumns = `id, timestamp, org_id, actor_user_id, action, resource_type, resource_id, changes, request_id, ip_address, prev_hash, hash`
+
+// Fields that are never written to the audit log in clear text. Changes to
+// them are still recorded, as "[REDACTED]".
+var redactedAuditFields = map[string]bool{
+	"key":            true,
+	"signing_secret": true,
+	"password":       true,
+}
+
+// AuditService appends admin mutations to a per-organization hash chain and
+// verifies it. With a key configured, entries are chained with HMAC-SHA256
+// so that rewriting the log also requires the key, not just database access.
+type AuditService struct {
+	db      *pgxpool.Pool
+	hmacKey []byte
+}
+
+func NewAuditService(db *pgxpool.Pool, hmacKey string) *AuditService {
+	return &AuditService{db: db, hmacKey: []byte(hmacKey)}
+}
+
+func scanAuditEntry(row pgx.Row) (*models.AuditEntry, error) {
+	entry := &models.AuditEntry{}
+	err := row.Scan(&entry.ID, &entry.Timestamp, &entry.OrgID, &entry.ActorUserID, &entry.Action, &entry.ResourceType, &entry.ResourceID, &entry.Changes, &entry.RequestID, &entry.IPAddress, &entry.PrevHash, &entry.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// Record appends an entry to its organization's chain in a transaction of
+// its own, filling in the timestamp, hashes and ID. It is for actions that
+// change nothing in the database; database mutations are recorded through an
+// Auditor, in the transaction that makes them.
+func (s *AuditService) Record(ctx context.Context, entry *models.AuditEntry) error {
+	return withTx(ctx, s.db, func(tx pgx.Tx) error {
+		return s.record(ctx, tx, entry)
+	})
+}
+
+// Auditor records a mutation in the transaction that makes it, so the change
+// commits only together with its audit entry. Pass nil for before on
+// creation and nil for after on deletion.
+type Auditor func(ctx context.Context, tx pgx.Tx, orgID int64, resourceID interface{}, before, after interface{}) error
+
+// Auditor returns an Auditor that records entries like template, with the
+// organization, resource ID and changes filled in from each mutation.
+func (s *AuditService) Auditor(template models.AuditEntry) Auditor {
+	return func(ctx context.Context, tx pgx.Tx, orgID int64, resourceID interface{}, before, after interface{}) error {
+		changes, err := AuditChanges(before, after)
+		if err != nil {
+			return fmt.Errorf("failed to encode audit changes: %w", err)
+		}
+		entry := template
+		entry.OrgID = &orgID
+		entry.ResourceID = fmt.Sprint(resourceID)
+		entry.Changes = changes
+		return s.record(ctx, tx, &entry)
+	}
+}
+
+func (s *AuditService) record(ctx context.Context, tx pgx.Tx, entry *models.AuditEntry) error {
+	changes, err := canonicalJSON(entry.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+	entry.Changes = changes
+	// Postgres keeps microseconds; hash exactly what will be stored.
+	entry.Timestamp = time.Now().UTC().Truncate(time.Microsecond)
+
+	// Serialize writers per organization so each entry links to the latest.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended('audit_log:' || COALESCE($1::bigint, 0)::text, 0))`, entry.OrgID); err != nil {
+		return fmt.Errorf("failed to lock audit log: %w", err)
+	}
+
+	err = tx.QueryRow(
+		ctx,
+		`SELECT hash FROM audit_log WHERE org_id IS NOT DISTINCT FROM $1 ORDER BY id DESC LIMIT 1`,
+		entry.OrgID,
+	).Scan(&entry.PrevHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		entry.PrevHash = auditGenesisHash
+	} else if err != nil {
+		return fmt.Errorf("failed to read audit chain: %w", err)
+	}
+
+	entry.Hash, err = s.hash(entry)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO audit_log (timestamp, org_id, actor_user_id, action, resource_type, resource_id, changes, request_id, ip_address, prev_hash, hash)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		 RETURNING id`,
+		entry.Timestamp, entry.OrgID, entry.ActorUserID, entry.Action, entry.ResourceType, entry.ResourceID, entry.Changes, entry.RequestID, entry.IPAddress, entry.PrevHash, entry.Hash,
+	).Scan(&entry.ID)
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// withTx runs fn in a transaction and commits it if fn succeeds.
+func withTx(ctx context.Context, db *pgxpool.Pool, fn func(pgx.Tx) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// List returns the actor's organization's entries, newest first.
+func (s *AuditService) List(ctx context.Context, actor *models.Membership, filter *models.AuditFilter) (*models.AuditPage, error) {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditPageSize
+	}
+	if limit > maxAuditPageSize {
+		limit = maxAuditPageSize
+	}
+
+	conditions := []string{"org_id = $1"}
+	args := []interface{}{actor.OrgID}
+	addCondition := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.ActorUserID != "" {
+		addCondition("actor_user_id = $%d", filter.ActorUserID)
+	}
+	if filter.Action != "" {
+		addCondition("action = $%d", filter.Action)
+	}
+	if filter.ResourceType != "" {
+		addCondition("resource_type = $%d", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		addCondition("resource_id = $%d", filter.ResourceID)
+	}
+	if filter.Since != nil {
+		addCondition("timestamp >= $%d", filter.Since.UTC())
+	}
+	if filter.Until != nil {
+		addCondition("timestamp <= $%d", filter.Until.UTC())
+	}
+	if filter.BeforeID > 0 {
+		addCondition("id < $%d", filter.BeforeID)
+	}
+	// Fetch one extra row to learn whether there is another page.
+	args = append(args, limit+1)
+
+	rows, err := s.db.Query(
+		ctx,
+		`SELECT `+auditColumns+`
+		 FROM audit_log WHERE `+strings.Join(conditions, " AND ")+`
+		 ORDER BY id DESC LIMIT $`+fmt.Sprint(len(args)),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.AuditPage{Entries: []*models.AuditEntry{}}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+
+	if len(page.Entries) > limit {
+		page.Entries = page.Entries[:limit]
+		cursor := page.Entries[limit-1].ID
+		page.NextCursor = &cursor
+	}
+	return page, nil
+}
+
+// Verify walks the actor's organization's chain from the start and reports
+// the first entry whose hash or link does not match.
+func (s *AuditService) Verify(ctx context.Context, actor *models.Membership) (*models.AuditVerification, error) {
+	if err := Authorize(actor, models.RoleAdmin); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(
+		ctx,
+		`SELECT `+auditColumns+` FROM audit_log WHERE org_id = $1 ORDER BY id`,
+		actor.OrgID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	defer rows.Close()
+
+	result := &models.AuditVerification{Valid: true}
+	prevHash := auditGenesisHash
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		result.Checked++
+
+		if entry.Changes, err = canonicalJSON(entry.Changes); err != nil {
+			return nil, fmt.Errorf("failed to decode audit changes: %w", err)
+		}
+		expected, err := s.hash(entry)
+		if err != nil {
+			return nil, err
+		}
+		if entry.PrevHash != prevHash || !hmac.Equal([]byte(entry.Hash), []byte(expected)) {
+			result.Valid = false
+			result.FirstInvalidID = &entry.ID
+			return result, nil
+		}
+		prevHash = entry.Hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	return result, nil
+}
+
+func (s *AuditService) hash(entry *models.AuditEntry) (string, error) {
+	data, err := json.Marshal(struct {
+		PrevHash     string          `json:"prev_hash"`
+		Timestamp    string          `json:"timestamp"`
+		OrgID        *int64          `json:"org_id"`
+		ActorUserID  string          `json:"actor_user_id"`
+		Action       string          `json:"action"`
+		ResourceType string          `json:"resource_type"`
+		ResourceID   string          `json:"resource_id"`
+		Changes      json.RawMessage `json:"changes"`
+		RequestID    string          `json:"request_id"`
+		IPAddress    string          `json:"ip_address"`
+	}{
+		PrevHash:     entry.PrevHash,
+		Timestamp:    entry.Timestamp.UTC().Format(time.RFC3339Nano),
+		OrgID:        entry.OrgID,
+		ActorUserID:  entry.ActorUserID,
+		Action:       entry.Action,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		Changes:      entry.Changes,
+		RequestID:    entry.RequestID,
+		IPAddress:    entry.IPAddress,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	var h hash.Hash
+	if len(s.hmacKey) > 0 {
+		h = hmac.New(sha256.New, s.hmacKey)
+	} else {
+		h = sha256.New()
+	}
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// canonicalJSON re-encodes a JSON document with sorted object keys, so that
+// the hash does not depend on how Postgres stores JSONB.
+func canonicalJSON(data json.RawMessage) (json.RawMessage, error) {
+	if len(data) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// AuditChanges describes a mutation for the audit log. Pass nil for before
+// on creation and nil for after on deletion; for updates only the top-level
+// fields that differ are included.
+func AuditChanges(before, after interface{}) (json.RawMessage, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	if beforeFields != nil && afterFields != nil {
+		for name, value := range beforeFields {
+			if other, ok := afterFields[name]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeFields, name)
+				delete(afterFields, name)
+			}
+		}
+	}
+
+	changes := map[string]interface{}{}
+	if beforeFields != nil {
+		changes["before"] = beforeFields
+	}
+	if afterFields != nil {
+		changes["after"] = afterFields
+	}
+	return json.Marshal(changes)
+}
+
+func auditFields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Ptr && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name := range fields {
+		if redactedAuditFields[name] {
+			fields[name] = "[REDACTED]"
+		}
+	}
+	return fields, nil
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl holds the directives of Cache-Control headers, keyed by
+// lower-case name. Directives without an argument map to "".
+type CacheControl map[string]string
+
+func ParseCacheControl(header http.Header) CacheControl {
+	cc := CacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// Seconds returns a delta-seconds directive such as max-age. Invalid values
+// are reported as absent.
+func (cc CacheControl) Seconds(directive string) (time.Duration, bool) {
+	arg, ok := cc[directive]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// Headers that describe a single connection and are never stored.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// ResponseFreshness decides, following RFC 9111, whether a response may be
+// stored by the gateway's shared cache and for how long it stays fresh.
+// defaultTTL applies when the response carries no explicit lifetime. Callers
+// decide which statuses are cacheable at all.
+func ResponseFreshness(reqHeader http.Header, header http.Header, defaultTTL time.Duration, now time.Time) (time.Duration, bool) {
+	reqCC := ParseCacheControl(reqHeader)
+	cc := ParseCacheControl(header)
+	if reqCC.Has("no-store") || cc.Has("no-store") || cc.Has("private") || cc.Has("no-cache") {
+		return 0, false
+	}
+	// Responses that set cookies belong to one client.
+	if header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	if _, ok := VaryHeaders(header); !ok {
+		return 0, false
+	}
+	// A shared cache only stores responses to authenticated requests when
+	// the origin explicitly allows it.
+	if reqHeader.Get("Authorization") != "" && !cc.Has("public") && !cc.Has("s-maxage") && !cc.Has("must-revalidate") {
+		return 0, false
+	}
+
+	if ttl, ok := cc.Seconds("s-maxage"); ok {
+		return ttl, ttl > 0
+	}
+	if ttl, ok := cc.Seconds("max-age"); ok {
+		return ttl, ttl > 0
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			// An invalid Expires means already expired.
+			return 0, false
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		ttl := expiresAt.Sub(date)
+		return ttl, ttl > 0
+	}
+	return defaultTTL, defaultTTL > 0
+}
+
+// UsableForRequest reports whether a stored response may answer the request
+// without contacting the upstream, honoring the request's no-cache and
+// max-age directives.
+func UsableForRequest(reqHeader http.Header, entry *CachedResponse, now time.Time) bool {
+	cc := ParseCacheControl(reqHeader)
+	if cc.Has("no-cache") {
+		return false
+	}
+	if maxAge, ok := cc.Seconds("max-age"); ok && entry.Age(now) > maxAge {
+		return false
+	}
+	return entry.Fresh(now)
+}
+
+// StaleWindows returns how long past its freshness lifetime a response may be
+// served while it is revalidated, and when the upstream fails. The
+// stale-while-revalidate and stale-if-error directives (RFC 5861) override
+// the rule's defaults; must-revalidate and proxy-revalidate forbid both.
+func StaleWindows(header http.Header, whileRevalidate, ifError time.Duration) (time.Duration, time.Duration) {
+	cc := ParseCacheControl(header)
+	if cc.Has("must-revalidate") || cc.Has("proxy-revalidate") {
+		return 0, 0
+	}
+	if d, ok := cc.Seconds("stale-while-revalidate"); ok {
+		whileRevalidate = d
+	}
+	if d, ok := cc.Seconds("stale-if-error"); ok {
+		ifError = d
+	}
+	return whileRevalidate, ifError
+}
+
+// ServableStale reports whether a stale entry may answer the request when it
+// is at most window past its freshness lifetime. Requests with no-cache or a
+// max-age the entry exceeds never get stale responses.
+func ServableStale(reqHeader http.Header, entry *CachedResponse, window time.Duration, now time.Time) bool {
+	cc := ParseCacheControl(reqHeader)
+	if cc.Has("no-cache") {
+		return false
+	}
+	if maxAge, ok := cc.Seconds("max-age"); ok && entry.Age(now) > maxAge {
+		return false
+	}
+	return window > 0 && entry.Age(now) < entry.TTL+window
+}
+
+// ConditionalRequestHeaders are the client preconditions the gateway answers
+// itself for cached routes. They are not forwarded, so that the upstream
+// returns a full response that can be stored.
+var ConditionalRequestHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// NotModifiedHeaders are the response headers repeated on a 304 Not Modified
+// (RFC 9110, section 15.4.5).
+var NotModifiedHeaders = []string{"Cache-Control", "Content-Location", "Date", "ETag", "Expires", "Last-Modified", "Vary"}
+
+// NotModified reports whether a request's If-None-Match or, without it,
+// If-Modified-Since precondition holds for a response, so that the client's
+// copy is current and a 304 can be sent (RFC 9110, section 13.2.2).
+func NotModified(reqHeader, header http.Header) bool {
+	if values := reqHeader.Values("If-None-Match"); len(values) > 0 {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, value := range values {
+			for _, candidate := range strings.Split(value, ",") {
+				candidate = strings.TrimSpace(candidate)
+				// If-None-Match uses the weak comparison.
+				if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(reqHeader.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !lastModified.After(since)
+}
+
+// RevalidationHeaders returns the request headers for revalidating a stored
+// response upstream: the request's own headers without client preconditions,
+// plus the stored response's validators.
+func RevalidationHeaders(reqHeader http.Header, stored *CachedResponse) http.Header {
+	header := reqHeader.Clone()
+	for _, name := range ConditionalRequestHeaders {
+		header.Del(name)
+	}
+	if stored == nil {
+		return header
+	}
+	// Generated ETags mean nothing to the upstream.
+	if etag := stored.Header.Get("ETag"); etag != "" && !stored.GeneratedETag {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := stored.Header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	return header
+}
+
+// MergeNotModified updates a stored response's headers with those of a 304
+// Not Modified received when revalidating it (RFC 9111, section 4.3.4).
+func MergeNotModified(stored, notModified http.Header) http.Header {
+	merged := stored.Clone()
+	for name, values := range notModified {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Content-Type", "Content-Encoding", "Content-Range":
+			// These describe the stored body, not the empty 304.
+			continue
+		}
+		merged[name] = values
+	}
+	return merged
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// headers builds an http.Header from name, value pairs.
+func headers(pairs ...string) http.Header {
+	h := http.Header{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		h.Add(pairs[i], pairs[i+1])
+	}
+	return h
+}
+
+func TestNotModified(t *testing.T) {
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	tests := []struct {
+		name      string
+		reqHeader http.Header
+		header    http.Header
+		want      bool
+	}{
+		{name: "strong match", reqHeader: headers("If-None-Match", `"v1"`), header: headers("ETag", `"v1"`), want: true},
+		{name: "weak request, strong etag", reqHeader: headers("If-None-Match", `W/"v1"`), header: headers("ETag", `"v1"`), want: true},
+		{name: "strong request, weak etag", reqHeader: headers("If-None-Match", `"v1"`), header: headers("ETag", `W/"v1"`), want: true},
+		{name: "both weak", reqHeader: headers("If-None-Match", `W/"v1"`), header: headers("ETag", `W/"v1"`), want: true},
+		{name: "mismatch", reqHeader: headers("If-None-Match", `"v1"`), header: headers("ETag", `"v2"`)},
+		{name: "weak mismatch", reqHeader: headers("If-None-Match", `W/"v1"`), header: headers("ETag", `W/"v2"`)},
+		{name: "match in list", reqHeader: headers("If-None-Match", `"v0", W/"v1"`), header: headers("ETag", `"v1"`), want: true},
+		{name: "match in second header", reqHeader: headers("If-None-Match", `"v0"`, "If-None-Match", `"v1"`), header: headers("ETag", `"v1"`), want: true},
+		{name: "star", reqHeader: headers("If-None-Match", "*"), header: headers("ETag", `"v1"`), want: true},
+		{name: "response without etag", reqHeader: headers("If-None-Match", "*"), header: headers()},
+		{
+			name:      "if-none-match wins over if-modified-since",
+			reqHeader: headers("If-None-Match", `"v0"`, "If-Modified-Since", lastModified),
+			header:    headers("ETag", `"v1"`, "Last-Modified", lastModified),
+		},
+		{name: "not modified since", reqHeader: headers("If-Modified-Since", lastModified), header: headers("Last-Modified", lastModified), want: true},
+		{
+			name:      "modified since",
+			reqHeader: headers("If-Modified-Since", "Sun, 01 Jan 2006 15:04:05 GMT"),
+			header:    headers("Last-Modified", lastModified),
+		},
+		{name: "invalid if-modified-since", reqHeader: headers("If-Modified-Since", "yesterday"), header: headers("Last-Modified", lastModified)},
+		{name: "no preconditions", reqHeader: headers(), header: headers("ETag", `"v1"`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NotModified(tt.reqHeader, tt.header); got != tt.want {
+				t.Fatalf("NotModified = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	defaultTTL := 5 * time.Minute
+	tests := []struct {
+		name      string
+		reqHeader http.Header
+		header    http.Header
+		wantTTL   time.Duration
+		wantStore bool
+	}{
+		{name: "default ttl", reqHeader: headers(), header: headers(), wantTTL: defaultTTL, wantStore: true},
+		{name: "max-age", reqHeader: headers(), header: headers("Cache-Control", "max-age=60"), wantTTL: time.Minute, wantStore: true},
+		{name: "s-maxage wins over max-age", reqHeader: headers(), header: headers("Cache-Control", "max-age=60, s-maxage=120"), wantTTL: 2 * time.Minute, wantStore: true},
+		{name: "max-age zero", reqHeader: headers(), header: headers("Cache-Control", "max-age=0")},
+		{
+			name:      "expires",
+			reqHeader: headers(),
+			header:    headers("Date", now.Format(http.TimeFormat), "Expires", now.Add(time.Hour).Format(http.TimeFormat)),
+			wantTTL:   time.Hour,
+			wantStore: true,
+		},
+		{name: "invalid expires", reqHeader: headers(), header: headers("Expires", "0")},
+		{name: "request no-store", reqHeader: headers("Cache-Control", "no-store"), header: headers("Cache-Control", "max-age=60")},
+		{name: "response no-store", reqHeader: headers(), header: headers("Cache-Control", "max-age=60, no-store")},
+		{name: "private", reqHeader: headers(), header: headers("Cache-Control", "private, max-age=60")},
+		{name: "no-cache", reqHeader: headers(), header: headers("Cache-Control", "no-cache")},
+		{name: "directives are case-insensitive", reqHeader: headers(), header: headers("Cache-Control", "No-Store")},
+		{name: "set-cookie", reqHeader: headers(), header: headers("Set-Cookie", "session=1")},
+		{name: "vary star", reqHeader: headers(), header: headers("Vary", "*")},
+		{name: "authorization", reqHeader: headers("Authorization", "Bearer t"), header: headers("Cache-Control", "max-age=60")},
+		{name: "authorization without cache-control", reqHeader: headers("Authorization", "Bearer t"), header: headers()},
+		{
+			name:      "authorization with public",
+			reqHeader: headers("Authorization", "Bearer t"),
+			header:    headers("Cache-Control", "public, max-age=60"),
+			wantTTL:   time.Minute,
+			wantStore: true,
+		},
+		{
+			name:      "authorization with s-maxage",
+			reqHeader: headers("Authorization", "Bearer t"),
+			header:    headers("Cache-Control", "s-maxage=30"),
+			wantTTL:   30 * time.Second,
+			wantStore: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, store := ResponseFreshness(tt.reqHeader, tt.header, defaultTTL, now)
+			if store != tt.wantStore {
+				t.Fatalf("store = %v, want %v", store, tt.wantStore)
+			}
+			if store && ttl != tt.wantTTL {
+				t.Fatalf("ttl = %v, want %v", ttl, tt.wantTTL)
+			}
+		})
+	}
+}
+
+func TestRevalidationHeaders(t *testing.T) {
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	tests := []struct {
+		name                string
+		stored              *CachedResponse
+		wantIfNoneMatch     string
+		wantIfModifiedSince string
+	}{
+		{name: "no stored response"},
+		{
+			name:                "stored validators",
+			stored:              &CachedResponse{Header: headers("ETag", `"v1"`, "Last-Modified", lastModified)},
+			wantIfNoneMatch:     `"v1"`,
+			wantIfModifiedSince: lastModified,
+		},
+		{
+			name:   "generated etag is not sent",
+			stored: &CachedResponse{Header: headers("ETag", `W/"abc"`), GeneratedETag: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqHeader := headers("If-None-Match", `"client"`, "If-Modified-Since", "Sun, 01 Jan 2006 15:04:05 GMT", "Accept", "application/json")
+			got := RevalidationHeaders(reqHeader, tt.stored)
+			if v := got.Get("If-None-Match"); v != tt.wantIfNoneMatch {
+				t.Fatalf("If-None-Match = %q, want %q", v, tt.wantIfNoneMatch)
+			}
+			if v := got.Get("If-Modified-Since"); v != tt.wantIfModifiedSince {
+				t.Fatalf("If-Modified-Since = %q, want %q", v, tt.wantIfModifiedSince)
+			}
+			if v := got.Get("Accept"); v != "application/json" {
+				t.Fatalf("Accept = %q, want %q", v, "application/json")
+			}
+			if reqHeader.Get("If-None-Match") != `"client"` {
+				t.Fatalf("RevalidationHeaders modified the request headers")
+			}
+		})
+	}
+}
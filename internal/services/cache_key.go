@@ -0,0 +1,168 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultCacheKeyPattern keys responses on everything that can change them
+// for an anonymous cache: method, path, full query string and body.
+const DefaultCacheKeyPattern = "method path query body"
+
+var ErrInvalidCacheRule = errors.New("invalid cache rule")
+
+// CacheKeyInput is the request data a cache key pattern can draw on.
+type CacheKeyInput struct {
+	Request *http.Request
+	Body    []byte
+	// Zero or empty when the request was not made with an API key or JWT.
+	APIKeyID int64
+	OrgID    int64
+	Consumer string
+}
+
+// CacheKeyPattern is a parsed cache_key_pattern: a space-separated list of
+// components, each contributing part of the request to the key.
+//
+//	method           request method
+//	path             request path
+//	segment:N        Nth path segment, counting from 0
+//	query            all query parameters, sorted and re-encoded
+//	query:a,b        only the listed query parameters
+//	header:A,B       the listed request headers
+//	apikey           the API key ID
+//	org              the API key's organization
+//	consumer         the JWT issuer and subject
+//	body             the request body
+//
+// "*" is accepted as an alias for DefaultCacheKeyPattern.
+type CacheKeyPattern struct {
+	components []cacheKeyComponent
+}
+
+type cacheKeyComponent struct {
+	kind string
+	args []string
+}
+
+func ParseCacheKeyPattern(pattern string) (*CacheKeyPattern, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || pattern == "*" {
+		pattern = DefaultCacheKeyPattern
+	}
+
+	p := &CacheKeyPattern{}
+	for _, field := range strings.Fields(pattern) {
+		kind, arg, hasArg := strings.Cut(field, ":")
+		component := cacheKeyComponent{kind: strings.ToLower(kind)}
+		if hasArg {
+			for _, a := range strings.Split(arg, ",") {
+				if a = strings.TrimSpace(a); a != "" {
+					component.args = append(component.args, a)
+				}
+			}
+			if len(component.args) == 0 {
+				return nil, fmt.Errorf("%w: %q needs at least one name", ErrInvalidCacheRule, field)
+			}
+		}
+
+		switch component.kind {
+		case "method", "path", "apikey", "org", "consumer", "body":
+			if hasArg {
+				return nil, fmt.Errorf("%w: %q takes no arguments", ErrInvalidCacheRule, kind)
+			}
+		case "query":
+			sort.Strings(component.args)
+		case "header":
+			for i, name := range component.args {
+				component.args[i] = http.CanonicalHeaderKey(name)
+			}
+			sort.Strings(component.args)
+		case "segment":
+			if len(component.args) != 1 {
+				return nil, fmt.Errorf("%w: segment takes one index", ErrInvalidCacheRule)
+			}
+			if n, err := strconv.Atoi(component.args[0]); err != nil || n < 0 {
+				return nil, fmt.Errorf("%w: invalid segment index %q", ErrInvalidCacheRule, component.args[0])
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown cache key component %q", ErrInvalidCacheRule, kind)
+		}
+		p.components = append(p.components, component)
+	}
+	return p, nil
+}
+
+// Includes reports whether the pattern has a component of the given kind.
+func (p *CacheKeyPattern) Includes(kind string) bool {
+	for _, component := range p.components {
+		if component.kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Key hashes the selected parts of the request into a cache key.
+func (p *CacheKeyPattern) Key(in *CacheKeyInput) string {
+	h := sha256.New()
+	for _, component := range p.components {
+		// Length-prefix values so that adjacent components cannot run together.
+		value := component.value(in)
+		fmt.Fprintf(h, "%s=%d:%s\n", component.kind, len(value), value)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c cacheKeyComponent) value(in *CacheKeyInput) string {
+	r := in.Request
+	switch c.kind {
+	case "method":
+		return r.Method
+	case "path":
+		return r.URL.Path
+	case "segment":
+		n, _ := strconv.Atoi(c.args[0])
+		segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if n < len(segments) {
+			return segments[n]
+		}
+		return ""
+	case "query":
+		query := r.URL.Query()
+		if len(c.args) == 0 {
+			// Encode sorts by name, so parameter order does not matter.
+			return query.Encode()
+		}
+		selected := url.Values{}
+		for _, name := range c.args {
+			if values, ok := query[name]; ok {
+				selected[name] = values
+			}
+		}
+		return selected.Encode()
+	case "header":
+		values := make([]string, 0, len(c.args))
+		for _, name := range c.args {
+			values = append(values, name+":"+strings.Join(r.Header.Values(name), ","))
+		}
+		return strings.Join(values, "\n")
+	case "apikey":
+		return strconv.FormatInt(in.APIKeyID, 10)
+	case "org":
+		return strconv.FormatInt(in.OrgID, 10)
+	case "consumer":
+		return in.Consumer
+	case "body":
+		sum := sha256.Sum256(in.Body)
+		return hex.EncodeToString(sum[:])
+	}
+	return ""
+}
@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCacheKeyPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{name: "default", pattern: DefaultCacheKeyPattern},
+		{name: "empty uses default", pattern: "  "},
+		{name: "star uses default", pattern: "*"},
+		{name: "all components", pattern: "method path segment:2 query:page,sort header:Accept apikey org consumer body"},
+		{name: "upper case kinds", pattern: "METHOD Path"},
+		{name: "unknown component", pattern: "method cookie", wantErr: true},
+		{name: "argument on plain component", pattern: "method:get", wantErr: true},
+		{name: "query without names", pattern: "query:", wantErr: true},
+		{name: "header with only commas", pattern: "header:,,", wantErr: true},
+		{name: "segment without number", pattern: "segment:x", wantErr: true},
+		{name: "negative segment", pattern: "segment:-1", wantErr: true},
+		{name: "segment with two indexes", pattern: "segment:1,2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCacheKeyPattern(tt.pattern)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCacheRule) {
+					t.Fatalf("ParseCacheKeyPattern(%q) error = %v, want ErrInvalidCacheRule", tt.pattern, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCacheKeyPattern(%q) error = %v", tt.pattern, err)
+			}
+		})
+	}
+}
+
+func TestCacheKeyPatternKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		a, b    *CacheKeyInput
+		same    bool
+	}{
+		{
+			name:    "query order is ignored",
+			pattern: "path query",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?b=1&a=2", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?a=2&b=1", nil)},
+			same:    true,
+		},
+		{
+			name:    "selected query order is ignored",
+			pattern: "path query:b,a",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?b=1&a=2", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?a=2&b=1", nil)},
+			same:    true,
+		},
+		{
+			name:    "unselected query parameters are ignored",
+			pattern: "path query:a",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?a=1&utm=x", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?a=1&utm=y", nil)},
+			same:    true,
+		},
+		{
+			name:    "query values differ",
+			pattern: "path query",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?a=1", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items?a=2", nil)},
+		},
+		{
+			name:    "path left out of the pattern",
+			pattern: "method query",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items/1?a=1", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items/2?a=1", nil)},
+			same:    true,
+		},
+		{
+			name:    "segment",
+			pattern: "segment:1",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items/1/detail", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items/1/summary", nil)},
+			same:    true,
+		},
+		{
+			name:    "body",
+			pattern: "method path body",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("POST", "/search", nil), Body: []byte(`{"q":"a"}`)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("POST", "/search", nil), Body: []byte(`{"q":"b"}`)},
+		},
+		{
+			name:    "method separates GET from an empty POST",
+			pattern: "method path query body",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/search?q=a", nil)},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("POST", "/search?q=a", nil)},
+		},
+		{
+			name:    "api key",
+			pattern: "path apikey",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items", nil), APIKeyID: 1},
+			b:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/items", nil), APIKeyID: 2},
+		},
+		{
+			// Without length prefixes both inputs would hash
+			// "consumer=a\npath=/b\npath=/c\n".
+			name:    "values cannot run into the next component",
+			pattern: "consumer path",
+			a:       &CacheKeyInput{Request: httptest.NewRequest("GET", "/c", nil), Consumer: "a\npath=/b"},
+			b:       &CacheKeyInput{Request: requestWithPath("/b\npath=/c"), Consumer: "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseCacheKeyPattern(tt.pattern)
+			if err != nil {
+				t.Fatalf("ParseCacheKeyPattern(%q) error = %v", tt.pattern, err)
+			}
+			if same := p.Key(tt.a) == p.Key(tt.b); same != tt.same {
+				t.Fatalf("keys equal = %v, want %v", same, tt.same)
+			}
+		})
+	}
+}
+
+func TestValidateCacheRuleMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		methods []string
+		wantErr bool
+	}{
+		{name: "get only", pattern: "path query", methods: []string{"GET"}},
+		{name: "post with body", pattern: "path query body", methods: []string{"POST"}},
+		{name: "post without body", pattern: "method path query", methods: []string{"POST"}, wantErr: true},
+		{name: "get and post with method", pattern: "method path query body", methods: []string{"GET", "POST"}},
+		{name: "get and post without method", pattern: "path query body", methods: []string{"GET", "POST"}, wantErr: true},
+		{name: "get and head without method", pattern: "path query", methods: []string{"GET", "HEAD"}, wantErr: true},
+		{name: "get and head with method", pattern: "method path", methods: []string{"GET", "HEAD"}},
+		{name: "uncacheable method", pattern: "method path", methods: []string{"GET", "PUT"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCacheRule(60, 0, 0, 0, tt.pattern, tt.methods, nil, 0)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidCacheRule) {
+					t.Fatalf("validateCacheRule error = %v, want ErrInvalidCacheRule", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateCacheRule error = %v", err)
+			}
+		})
+	}
+}
+
+func TestCacheKeyPatternHeaderNames(t *testing.T) {
+	lower, err := ParseCacheKeyPattern("header:accept-language")
+	if err != nil {
+		t.Fatalf("ParseCacheKeyPattern error = %v", err)
+	}
+	canonical, err := ParseCacheKeyPattern("header:Accept-Language")
+	if err != nil {
+		t.Fatalf("ParseCacheKeyPattern error = %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/items", nil)
+	r.Header.Set("Accept-Language", "de")
+	in := &CacheKeyInput{Request: r}
+	if lower.Key(in) != canonical.Key(in) {
+		t.Fatalf("header names in different case produced different keys")
+	}
+
+	other := httptest.NewRequest("GET", "/items", nil)
+	other.Header.Set("Accept-Language", "fr")
+	if lower.Key(in) == lower.Key(&CacheKeyInput{Request: other}) {
+		t.Fatalf("different header values produced the same key")
+	}
+}
+
+// requestWithPath sets URL.Path directly, for paths httptest cannot parse.
+func requestWithPath(path string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.URL.Path = path
+	return r
+}
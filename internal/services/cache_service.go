@@ -2,55 +2,954 @@ package services
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"gateway/internal/models"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 )
 
+// CachedResponse is an upstream response as stored in the cache.
+type CachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	StoredAt   time.Time   `json:"stored_at"`
+	// InitialAge is the upstream's Age header when the response was stored.
+	InitialAge time.Duration `json:"initial_age"`
+	// TTL is the freshness lifetime, counted from when the upstream
+	// generated the response.
+	TTL time.Duration `json:"ttl"`
+	// How long past TTL the response may still be served while it is
+	// refreshed, or when the upstream fails.
+	StaleWhileRevalidate time.Duration `json:"stale_while_revalidate,omitempty"`
+	StaleIfError         time.Duration `json:"stale_if_error,omitempty"`
+	// Tags are the surrogate keys the upstream attached to the response.
+	Tags []string `json:"tags,omitempty"`
+	// GeneratedETag is set when the gateway made up the ETag because the
+	// upstream sent none.
+	GeneratedETag bool `json:"generated_etag,omitempty"`
+	// Encoding is the content coding the gateway compressed Body with for
+	// storage, if any.
+	Encoding string `json:"encoding,omitempty"`
+	// Request is the method and URI of the request that stored the entry,
+	// for inspection by operators.
+	Request string `json:"request,omitempty"`
+}
+
+// Limits on surrogate keys, which come from upstream headers and admin
+// requests.
+const (
+	MaxCacheTagLength = 256
+	MaxPurgeTags      = 100
+)
+
+var ErrInvalidCacheTag = errors.New("invalid cache tag")
+
+var (
+	ErrCacheEntryTooLarge = errors.New("cache entry too large")
+	ErrCacheEntryNotFound = errors.New("cache entry not found")
+)
+
+// CacheTagHeaders carry surrogate keys from the upstream. They are meant for
+// the gateway only and are removed before responses reach clients.
+var CacheTagHeaders = []string{"Surrogate-Key", "Cache-Tag"}
+
+// ResponseCacheTags returns the surrogate keys of a response: space-separated
+// in Surrogate-Key and comma-separated in Cache-Tag. Tags that are too long
+// are ignored.
+func ResponseCacheTags(header http.Header) []string {
+	seen := map[string]bool{}
+	var tags []string
+	add := func(tag string) {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || len(tag) > MaxCacheTagLength || seen[tag] {
+			return
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	for _, value := range header.Values("Surrogate-Key") {
+		for _, tag := range strings.Fields(value) {
+			add(tag)
+		}
+	}
+	for _, value := range header.Values("Cache-Tag") {
+		for _, tag := range strings.Split(value, ",") {
+			add(tag)
+		}
+	}
+	return tags
+}
+
+// ValidatePurgeTags checks the tags of a purge request.
+func ValidatePurgeTags(tags []string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("%w: at least one tag is required", ErrInvalidCacheTag)
+	}
+	if len(tags) > MaxPurgeTags {
+		return fmt.Errorf("%w: at most %d tags per request", ErrInvalidCacheTag, MaxPurgeTags)
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > MaxCacheTagLength || strings.ContainsAny(tag, " \t\r\n,") {
+			return fmt.Errorf("%w: %q", ErrInvalidCacheTag, tag)
+		}
+	}
+	return nil
+}
+
+// NewCachedResponse captures a response for storage, dropping headers that
+// only apply to the connection it arrived on. Responses without an ETag get
+// one derived from the body, so that clients can revalidate against the
+// gateway.
+func NewCachedResponse(statusCode int, header http.Header, body []byte, ttl time.Duration, now time.Time) *CachedResponse {
+	stored := header.Clone()
+	for _, name := range hopByHopHeaders {
+		stored.Del(name)
+	}
+	stored.Del("Age")
+	stored.Del("X-Cache")
+	for _, name := range CacheTagHeaders {
+		stored.Del(name)
+	}
+
+	var initialAge time.Duration
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		initialAge = time.Duration(age) * time.Second
+	}
+
+	generatedETag := false
+	if stored.Get("ETag") == "" {
+		sum := sha256.Sum256(body)
+		stored.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		generatedETag = true
+	}
+
+	return &CachedResponse{
+		StatusCode:    statusCode,
+		Header:        stored,
+		Body:          body,
+		StoredAt:      now,
+		InitialAge:    initialAge,
+		TTL:           ttl,
+		Tags:          ResponseCacheTags(header),
+		GeneratedETag: generatedETag,
+	}
+}
+
+// Age is the response's current age as defined by RFC 9111.
+func (e *CachedResponse) Age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.StoredAt)
+}
+
+func (e *CachedResponse) Fresh(now time.Time) bool {
+	return e.Age(now) < e.TTL
+}
+
+// Expired reports whether the response is past every window in which it
+// could still be served.
+func (e *CachedResponse) Expired(now time.Time) bool {
+	return e.Age(now) >= e.TTL+e.staleWindow()
+}
+
+// DecodedBody returns the body as the upstream sent it.
+func (e *CachedResponse) DecodedBody() ([]byte, error) {
+	if e.Encoding == "" {
+		return e.Body, nil
+	}
+	return Decompress(e.Encoding, e.Body)
+}
+
+func (e *CachedResponse) staleWindow() time.Duration {
+	if e.StaleIfError > e.StaleWhileRevalidate {
+		return e.StaleIfError
+	}
+	return e.StaleWhileRevalidate
+}
+
+// Each cached resource is a Redis hash under its cache key. The "vary" field
+// holds the request headers named by the upstream's Vary header, and each
+// representation is stored in a "v:<id>" field, where the ID is derived from
+// the request's values for those headers.
+const (
+	cacheVaryField    = "vary"
+	cacheVariantField = "v:"
+)
+
+// Cache tiers a hit can be served from.
+const (
+	CacheTierLocal = "local"
+	CacheTierRedis = "redis"
+)
+
+// Deleted cache keys are published on this channel so that every instance
+// drops them from its local tier.
+const cacheInvalidationChannel = "cache:invalidations"
+
+// CacheOptions configures a CacheService.
+type CacheOptions struct {
+	// MaxVariants caps the representations stored per cache key.
+	MaxVariants int
+	// LockTimeout bounds how long instances wait on one another's fill of
+	// the same key; zero disables the cross-instance lock.
+	LockTimeout time.Duration
+	// Local is the in-process tier, nil when disabled.
+	Local *LocalCache
+	// MaxEntryBytes is the largest stored body for rules that set no limit
+	// of their own; zero means unlimited.
+	MaxEntryBytes int64
+	// Compression is the content coding bodies of at least CompressMinBytes
+	// are stored with; empty stores them as received.
+	Compression      string
+	CompressMinBytes int
+}
+
 type CacheService struct {
-	client *redis.Client
+	client           *redis.Client
+	maxVariants      int
+	lockTimeout      time.Duration
+	local            *LocalCache
+	maxEntryBytes    int64
+	compression      string
+	compressMinBytes int
 }
 
-func NewCacheService(client *redis.Client) *CacheService {
-	return &CacheService{client: client}
+func NewCacheService(client *redis.Client, opts CacheOptions) *CacheService {
+	if opts.MaxVariants < 1 {
+		opts.MaxVariants = 1
+	}
+	if !ValidEncoding(opts.Compression) {
+		opts.Compression = ""
+	}
+	return &CacheService{
+		client:           client,
+		maxVariants:      opts.MaxVariants,
+		lockTimeout:      opts.LockTimeout,
+		local:            opts.Local,
+		maxEntryBytes:    opts.MaxEntryBytes,
+		compression:      opts.Compression,
+		compressMinBytes: opts.CompressMinBytes,
+	}
 }
 
-func (c *CacheService) Get(ctx context.Context, key string) (string, bool, error) {
-	val, err := c.client.Get(ctx, key).Result()
+// Start applies invalidations published by other gateway instances to the
+// local tier until ctx is canceled. Messages missed while disconnected from
+// Redis are covered by the local tier's short TTL.
+func (c *CacheService) Start(ctx context.Context) {
+	if c.local == nil {
+		return
+	}
+
+	sub := c.client.Subscribe(ctx, cacheInvalidationChannel)
+	defer sub.Close()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var keys []string
+			if err := json.Unmarshal([]byte(msg.Payload), &keys); err != nil {
+				log.Printf("Invalid cache invalidation message: %v", err)
+				continue
+			}
+			c.local.remove(keys...)
+		}
+	}
+}
+
+// Get returns the stored representation matching the request's headers and
+// the tier it was found in, or an empty tier on a miss. Fresh entries are
+// served from the local tier; anything else is read from Redis.
+func (c *CacheService) Get(ctx context.Context, key string, reqHeader http.Header) (*CachedResponse, string, error) {
+	now := time.Now()
+	if entry, ok := c.local.get(key, reqHeader, now); ok && entry.Fresh(now) {
+		return entry, CacheTierLocal, nil
+	}
+
+	vary, err := c.client.HGet(ctx, key, cacheVaryField).Result()
+	if err == redis.Nil {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get cache: %w", err)
+	}
+
+	varyNames := splitVary(vary)
+	field := variantField(varyNames, reqHeader)
+	data, err := c.client.HGet(ctx, key, field).Bytes()
 	if err == redis.Nil {
-		return "", false, nil
+		return nil, "", nil
 	}
 	if err != nil {
-		return "", false, fmt.Errorf("failed to get cache: %w", err)
+		return nil, "", fmt.Errorf("failed to get cache: %w", err)
 	}
 
-	return val, true, nil
+	entry := &CachedResponse{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, "", fmt.Errorf("failed to decode cache entry: %w", err)
+	}
+	c.local.add(key, varyNames, field, entry, now)
+	return entry, CacheTierRedis, nil
 }
 
-func (c *CacheService) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
-	if err := c.client.Set(ctx, key, value, ttl).Err(); err != nil {
+// Set stores a response, as the representation selected by the request's
+// headers, until it can no longer be served, even stale. Responses with "Vary: *" are not
+// stored. Once a resource has maxVariants representations, the oldest is
+// dropped to make room. The entry's tags are indexed under the organization
+// that owns the route, so that its backends and admins can purge them, and
+// the key is indexed under the request path, so that it can be invalidated
+// by path.
+//
+// Bodies are compressed for storage when that is configured and worthwhile;
+// entry itself is left as it is. Entries whose stored body is larger than
+// maxEntryBytes, or the service default when it is zero, are rejected with
+// ErrCacheEntryTooLarge.
+func (c *CacheService) Set(ctx context.Context, key string, route *models.Route, r *http.Request, entry *CachedResponse, maxEntryBytes int64) error {
+	ttl := entry.TTL + entry.staleWindow() - entry.InitialAge
+	if ttl <= 0 {
+		return nil
+	}
+
+	varyNames, ok := VaryHeaders(entry.Header)
+	if !ok {
+		return nil
+	}
+	vary := strings.Join(varyNames, ",")
+	field := variantField(varyNames, r.Header)
+
+	entry = c.compress(entry)
+	if maxEntryBytes <= 0 {
+		maxEntryBytes = c.maxEntryBytes
+	}
+	if maxEntryBytes > 0 && int64(len(entry.Body)) > maxEntryBytes {
+		return fmt.Errorf("%w: %d bytes exceeds %d", ErrCacheEntryTooLarge, len(entry.Body), maxEntryBytes)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %w", err)
+	}
+
+	// Representations stored under a different Vary cannot be told apart
+	// any more, so a changed Vary starts the resource afresh.
+	// Missing or unreadable resources (such as plain string entries written
+	// by older gateway versions) are replaced as well.
+	stored, err := c.client.HGet(ctx, key, cacheVaryField).Result()
+	reset := err != nil || stored != vary
+	if !reset {
+		if err := c.makeRoomForVariant(ctx, key, field); err != nil {
+			return err
+		}
+	}
+
+	// Keep the resource as long as its longest-lived representation.
+	keyTTL := ttl
+	if !reset {
+		if current, err := c.client.PTTL(ctx, key).Result(); err == nil && current > keyTTL {
+			keyTTL = current
+		}
+	}
+
+	pipe := c.client.TxPipeline()
+	if reset {
+		pipe.Del(ctx, key)
+	}
+	pipe.HSet(ctx, key, cacheVaryField, vary, field, data)
+	pipe.PExpire(ctx, key, keyTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
 		return fmt.Errorf("failed to set cache: %w", err)
 	}
+	// Other instances pick up the new entry when their copy expires.
+	c.local.remove(key)
+	indexKeys := []string{cachePathKey(route.ID, r.URL.Path)}
+	for _, tag := range entry.Tags {
+		indexKeys = append(indexKeys, cacheTagKey(route.OrgID, tag))
+	}
+	return c.index(ctx, indexKeys, key, keyTTL)
+}
+
+// compress returns a copy of entry with its body compressed, or entry itself
+// when it is too small, already encoded by the upstream, or would not shrink.
+func (c *CacheService) compress(entry *CachedResponse) *CachedResponse {
+	if c.compression == "" || entry.Encoding != "" || len(entry.Body) < c.compressMinBytes {
+		return entry
+	}
+	if encoding := entry.Header.Get("Content-Encoding"); encoding != "" && !strings.EqualFold(encoding, "identity") {
+		return entry
+	}
+	body, err := Compress(c.compression, entry.Body)
+	if err != nil || len(body) >= len(entry.Body) {
+		return entry
+	}
+	compressed := *entry
+	compressed.Body = body
+	compressed.Encoding = c.compression
+	return &compressed
+}
+
+// Each tag, and each request path of a route, is a Redis set of the cache
+// keys whose responses carried the tag or were stored for the path, kept at
+// least as long as the entries it points to. Members may outlive their
+// entries; purging simply finds nothing to delete for them.
+func cacheTagKey(orgID int64, tag string) string {
+	return fmt.Sprintf("cachetag:%d:%s", orgID, tag)
+}
+
+func cachePathKey(routeID int64, path string) string {
+	return fmt.Sprintf("cachepath:%d:%s", routeID, pathHash(path))
+}
+
+// index adds key to each of the index sets.
+func (c *CacheService) index(ctx context.Context, indexKeys []string, key string, ttl time.Duration) error {
+	pipe := c.client.Pipeline()
+	ttls := make([]*redis.DurationCmd, len(indexKeys))
+	for i, indexKey := range indexKeys {
+		pipe.SAdd(ctx, indexKey, key)
+		ttls[i] = pipe.PTTL(ctx, indexKey)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to index cache key: %w", err)
+	}
+
+	pipe = c.client.Pipeline()
+	for i, indexKey := range indexKeys {
+		if current := ttls[i].Val(); current < ttl {
+			pipe.PExpire(ctx, indexKey, ttl)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to index cache key: %w", err)
+	}
+	return nil
+}
+
+// PurgeTags deletes every cached entry tagged with any of the tags in the
+// organization's routes, and returns how many were deleted.
+func (c *CacheService) PurgeTags(ctx context.Context, orgID int64, tags []string) (int64, error) {
+	var deleted int64
+	for _, tag := range tags {
+		tagKey := cacheTagKey(orgID, tag)
+		n, err := c.deleteAll(ctx, c.client.SScan(ctx, tagKey, 0, "", 1000).Iterator(), nil)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+		if err := c.client.Unlink(ctx, tagKey).Err(); err != nil {
+			return deleted, fmt.Errorf("failed to delete cache tag: %w", err)
+		}
+	}
+	return deleted, nil
+}
+
+// makeRoomForVariant evicts representations when adding field would exceed
+// the per-resource limit: expired ones first, then the oldest.
+func (c *CacheService) makeRoomForVariant(ctx context.Context, key, field string) error {
+	count, err := c.client.HLen(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to count cache variants: %w", err)
+	}
+	if int(count)-1 < c.maxVariants {
+		return nil
+	}
+	exists, err := c.client.HExists(ctx, key, field).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check cache variant: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	fields, err := c.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list cache variants: %w", err)
+	}
+
+	now := time.Now()
+	var evict []string
+	var oldestField string
+	var oldest time.Time
+	for name, data := range fields {
+		if !strings.HasPrefix(name, cacheVariantField) {
+			continue
+		}
+		entry := &CachedResponse{}
+		if err := json.Unmarshal([]byte(data), entry); err != nil || entry.Expired(now) {
+			evict = append(evict, name)
+			continue
+		}
+		if oldestField == "" || entry.StoredAt.Before(oldest) {
+			oldestField, oldest = name, entry.StoredAt
+		}
+	}
+	if len(evict) == 0 && oldestField != "" {
+		evict = append(evict, oldestField)
+	}
+	if len(evict) == 0 {
+		return nil
+	}
+	if err := c.client.HDel(ctx, key, evict...).Err(); err != nil {
+		return fmt.Errorf("failed to evict cache variants: %w", err)
+	}
 	return nil
 }
 
-func (c *CacheService) Delete(ctx context.Context, pattern string) error {
-	iter := c.client.Scan(ctx, 0, pattern, 0).Iterator()
+// cacheFillPollInterval is how often instances waiting on another
+// instance's fill check whether it has finished.
+const cacheFillPollInterval = 50 * time.Millisecond
+
+// Deletes the fill lock only if it still holds this instance's token, so an
+// expired lock taken over by another instance is left alone.
+var releaseFillLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func cacheLockKey(key string) string {
+	return "cachelock:" + key
+}
+
+// AcquireFill takes the cross-instance lock for fetching a missing cache key
+// from upstream. When another instance holds it, acquired is false and the
+// caller should AwaitFill. Without a configured lock timeout, or when Redis
+// fails, every caller acquires it. release must be called once the response
+// has been stored, or found to be uncacheable.
+func (c *CacheService) AcquireFill(ctx context.Context, key string) (release func(), acquired bool) {
+	noop := func() {}
+	if c.lockTimeout <= 0 {
+		return noop, true
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return noop, true
+	}
+	token := hex.EncodeToString(b)
+
+	ok, err := c.client.SetNX(ctx, cacheLockKey(key), token, c.lockTimeout).Result()
+	if err != nil {
+		return noop, true
+	}
+	if !ok {
+		return noop, false
+	}
+	return func() {
+		releaseFillLock.Run(context.Background(), c.client, []string{cacheLockKey(key)}, token)
+	}, true
+}
+
+// AwaitFill waits, at most the lock timeout, for the instance holding the
+// fill lock to store the response, and returns it if it matches the request.
+// found is false when the holder stored nothing or took too long.
+func (c *CacheService) AwaitFill(ctx context.Context, key string, reqHeader http.Header) (entry *CachedResponse, found bool) {
+	ticker := time.NewTicker(cacheFillPollInterval)
+	defer ticker.Stop()
+	deadline := time.Now().Add(c.lockTimeout)
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, false
+		case <-ticker.C:
+		}
+
+		// Check the lock first: the holder stores before releasing, so once
+		// the lock is gone the following read sees anything it stored.
+		locked, err := c.client.Exists(ctx, cacheLockKey(key)).Result()
+		if entry, tier, err := c.Get(ctx, key, reqHeader); err == nil && tier != "" && entry.Fresh(time.Now()) {
+			return entry, true
+		}
+		if err != nil || locked == 0 {
+			// The holder finished without storing a matching response.
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Cache keys are namespaced as cache:<org>:<route>:<key hash>, where org is
+// the calling API key's organization (or the route's, for requests without
+// a key). Entries can then be found by route without an index, and tenants
+// sharing a route never share entries. The path only counts towards the key
+// as far as the pattern includes it, so entries are found by path through
+// the index Set keeps.
+func (c *CacheService) GenerateKey(orgID, routeID int64, pattern *CacheKeyPattern, in *CacheKeyInput) string {
+	return fmt.Sprintf("cache:%d:%d:%s", orgID, routeID, pattern.Key(in))
+}
+
+// InvalidateRoute deletes every tenant's entries for a route, or only those
+// stored for one request path when path is set, and returns how many were
+// deleted. Under a pattern without the path, an entry stored for another
+// path may also answer this one; it is only deleted with the whole route.
+func (c *CacheService) InvalidateRoute(ctx context.Context, routeID int64, path string) (int64, error) {
+	if path == "" {
+		return c.deleteAll(ctx, c.client.Scan(ctx, 0, fmt.Sprintf("cache:*:%d:*", routeID), 1000).Iterator(), nil)
+	}
+
+	pathKey := cachePathKey(routeID, path)
+	deleted, err := c.deleteAll(ctx, c.client.SScan(ctx, pathKey, 0, "", 1000).Iterator(), nil)
+	if err != nil {
+		return deleted, err
+	}
+	if err := c.client.Unlink(ctx, pathKey).Err(); err != nil {
+		return deleted, fmt.Errorf("failed to delete cache path index: %w", err)
+	}
+	return deleted, nil
+}
+
+// InvalidateRoutes is InvalidateRoute for several routes. Whole routes are
+// found with a single scan of the keyspace, however many there are.
+func (c *CacheService) InvalidateRoutes(ctx context.Context, routeIDs []int64, path string) (int64, error) {
+	if path != "" || len(routeIDs) == 1 {
+		var deleted int64
+		for _, routeID := range routeIDs {
+			n, err := c.InvalidateRoute(ctx, routeID, path)
+			deleted += n
+			if err != nil {
+				return deleted, err
+			}
+		}
+		return deleted, nil
+	}
+	if len(routeIDs) == 0 {
+		return 0, nil
+	}
+
+	wanted := make(map[int64]bool, len(routeIDs))
+	for _, routeID := range routeIDs {
+		wanted[routeID] = true
+	}
+	return c.deleteAll(ctx, c.client.Scan(ctx, 0, "cache:*", 1000).Iterator(), func(key string) bool {
+		_, routeID, ok := parseCacheKey(key)
+		return ok && wanted[routeID]
+	})
+}
+
+// RouteStats counts the cached resources of each route and the memory Redis
+// reports for them, across all tenants. Routes without entries are
+// included with zero counts.
+func (c *CacheService) RouteStats(ctx context.Context, routeIDs []int64) ([]*models.CacheStats, error) {
+	stats := make([]*models.CacheStats, 0, len(routeIDs))
+	byRoute := make(map[int64]*models.CacheStats, len(routeIDs))
+	for _, id := range routeIDs {
+		stat := &models.CacheStats{RouteID: id}
+		stats = append(stats, stat)
+		byRoute[id] = stat
+	}
+	if len(routeIDs) == 0 {
+		return stats, nil
+	}
+
+	match := "cache:*"
+	if len(routeIDs) == 1 {
+		match = fmt.Sprintf("cache:*:%d:*", routeIDs[0])
+	}
+
+	batch := make([]string, 0, 100)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		pipe := c.client.Pipeline()
+		usage := make([]*redis.IntCmd, len(batch))
+		for i, key := range batch {
+			usage[i] = pipe.MemoryUsage(ctx, key)
+		}
+		// Keys deleted since the scan report redis.Nil and are skipped.
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return fmt.Errorf("failed to measure cache keys: %w", err)
+		}
+		for i, key := range batch {
+			if usage[i].Err() != nil {
+				continue
+			}
+			_, routeID, _ := parseCacheKey(key)
+			stat := byRoute[routeID]
+			stat.Keys++
+			stat.Bytes += usage[i].Val()
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := c.client.Scan(ctx, 0, match, 1000).Iterator()
 	for iter.Next(ctx) {
-		if err := c.client.Del(ctx, iter.Val()).Err(); err != nil {
-			return fmt.Errorf("failed to delete cache key: %w", err)
+		if _, routeID, ok := parseCacheKey(iter.Val()); !ok || byRoute[routeID] == nil {
+			continue
+		}
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return nil, err
+			}
 		}
 	}
 	if err := iter.Err(); err != nil {
-		return fmt.Errorf("failed to iterate cache keys: %w", err)
+		return nil, fmt.Errorf("failed to iterate cache keys: %w", err)
 	}
-	return nil
+	if err := flush(); err != nil {
+		return nil, err
+	}
+	return stats, nil
 }
 
-func (c *CacheService) GenerateKey(path, method, body string) string {
-	hash := sha256.Sum256([]byte(path + method + body))
-	return "cache:" + hex.EncodeToString(hash[:])
+// ListEntries returns one page of a route's cached resources, optionally
+// limited to one request path. Redis scans in steps, so a page holds about
+// limit entries; next is zero after the last page.
+func (c *CacheService) ListEntries(ctx context.Context, routeID int64, path string, cursor uint64, limit int) (entries []*models.CacheEntry, next uint64, err error) {
+	scan := func(cursor uint64) *redis.ScanCmd {
+		return c.client.Scan(ctx, cursor, fmt.Sprintf("cache:*:%d:*", routeID), int64(limit))
+	}
+	if path != "" {
+		scan = func(cursor uint64) *redis.ScanCmd {
+			return c.client.SScan(ctx, cachePathKey(routeID, path), cursor, "", int64(limit))
+		}
+	}
+
+	var keys []string
+	for {
+		var batch []string
+		batch, cursor, err = scan(cursor).Result()
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan cache keys: %w", err)
+		}
+		keys = append(keys, batch...)
+		if cursor == 0 || len(keys) >= limit {
+			break
+		}
+	}
+
+	entries = make([]*models.CacheEntry, 0, len(keys))
+	for _, key := range keys {
+		entry, err := c.describeEntry(ctx, key)
+		if errors.Is(err, ErrCacheEntryNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, cursor, nil
+}
+
+// GetEntry describes one of a route's cached resources.
+func (c *CacheService) GetEntry(ctx context.Context, routeID int64, key string) (*models.CacheEntry, error) {
+	if _, keyRouteID, ok := parseCacheKey(key); !ok || keyRouteID != routeID {
+		return nil, ErrCacheEntryNotFound
+	}
+	return c.describeEntry(ctx, key)
+}
+
+func (c *CacheService) describeEntry(ctx context.Context, key string) (*models.CacheEntry, error) {
+	orgID, routeID, ok := parseCacheKey(key)
+	if !ok {
+		return nil, ErrCacheEntryNotFound
+	}
+
+	pipe := c.client.Pipeline()
+	fieldsCmd := pipe.HGetAll(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read cache entry: %w", err)
+	}
+	fields := fieldsCmd.Val()
+	if len(fields) == 0 {
+		return nil, ErrCacheEntryNotFound
+	}
+
+	now := time.Now()
+	entry := &models.CacheEntry{
+		Key:              key,
+		OrgID:            orgID,
+		RouteID:          routeID,
+		Vary:             splitVary(fields[cacheVaryField]),
+		ExpiresInSeconds: int64(ttlCmd.Val() / time.Second),
+		Variants:         []models.CacheVariant{},
+	}
+	for name, data := range fields {
+		entry.Size += int64(len(name) + len(data))
+		if !strings.HasPrefix(name, cacheVariantField) {
+			continue
+		}
+		stored := &CachedResponse{}
+		if err := json.Unmarshal([]byte(data), stored); err != nil {
+			continue
+		}
+		remaining := stored.TTL - stored.Age(now)
+		if remaining < 0 {
+			remaining = 0
+		}
+		entry.Variants = append(entry.Variants, models.CacheVariant{
+			Request:             stored.Request,
+			StatusCode:          stored.StatusCode,
+			ContentType:         stored.Header.Get("Content-Type"),
+			Encoding:            stored.Encoding,
+			Size:                int64(len(stored.Body)),
+			StoredAt:            stored.StoredAt,
+			AgeSeconds:          int64(stored.Age(now) / time.Second),
+			TTLRemainingSeconds: int64(remaining / time.Second),
+			Tags:                stored.Tags,
+		})
+	}
+	sort.Slice(entry.Variants, func(i, j int) bool {
+		return entry.Variants[i].StoredAt.After(entry.Variants[j].StoredAt)
+	})
+	return entry, nil
+}
+
+// parseCacheKey extracts the tenant and route from a cache key of the form
+// cache:<org>:<route>:<key hash>.
+func parseCacheKey(key string) (orgID, routeID int64, ok bool) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 4 || parts[0] != "cache" {
+		return 0, 0, false
+	}
+	orgID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	routeID, err = strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return orgID, routeID, true
+}
+
+// deleteAll unlinks the keys produced by iter in batches, skipping those
+// keep rejects when it is set.
+func (c *CacheService) deleteAll(ctx context.Context, iter *redis.ScanIterator, keep func(key string) bool) (int64, error) {
+	var deleted int64
+	batch := make([]string, 0, 100)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, err := c.client.Unlink(ctx, batch...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to delete cache keys: %w", err)
+		}
+		deleted += n
+		c.broadcastInvalidation(ctx, batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for iter.Next(ctx) {
+		if keep != nil && !keep(iter.Val()) {
+			continue
+		}
+		batch = append(batch, iter.Val())
+		if len(batch) == cap(batch) {
+			if err := flush(); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return deleted, fmt.Errorf("failed to iterate cache keys: %w", err)
+	}
+	if err := flush(); err != nil {
+		return deleted, err
+	}
+	return deleted, nil
+}
+
+// broadcastInvalidation drops deleted keys from the local tier of every
+// instance, including this one.
+func (c *CacheService) broadcastInvalidation(ctx context.Context, keys []string) {
+	if c.local == nil {
+		return
+	}
+	c.local.remove(keys...)
+	payload, err := json.Marshal(keys)
+	if err != nil {
+		return
+	}
+	if err := c.client.Publish(ctx, cacheInvalidationChannel, payload).Err(); err != nil {
+		log.Printf("Failed to publish cache invalidation: %v", err)
+	}
+}
+
+func pathHash(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:8])
+}
+
+// VaryHeaders returns the canonical, sorted request header names listed in
+// a response's Vary header. ok is false for "Vary: *", which matches no
+// later request.
+func VaryHeaders(header http.Header) (names []string, ok bool) {
+	seen := map[string]bool{}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name == "*" {
+				return nil, false
+			}
+			name = http.CanonicalHeaderKey(name)
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// SameVariant reports whether two requests select the same representation
+// of a response, i.e. agree on every header it varies on.
+func SameVariant(respHeader, a, b http.Header) bool {
+	names, ok := VaryHeaders(respHeader)
+	if !ok {
+		return false
+	}
+	return variantField(names, a) == variantField(names, b)
+}
+
+func splitVary(vary string) []string {
+	if vary == "" {
+		return nil
+	}
+	return strings.Split(vary, ",")
+}
+
+// variantField names the hash field holding the representation for the
+// request's values of the varying headers.
+func variantField(varyNames []string, reqHeader http.Header) string {
+	if len(varyNames) == 0 {
+		return cacheVariantField
+	}
+	h := sha256.New()
+	for _, name := range varyNames {
+		// Normalize list whitespace so "gzip, br" and "gzip,br" match.
+		values := []string{}
+		for _, value := range reqHeader.Values(name) {
+			for _, item := range strings.Split(value, ",") {
+				values = append(values, strings.TrimSpace(item))
+			}
+		}
+		fmt.Fprintf(h, "%s:%s\n", name, strings.Join(values, ","))
+	}
+	return cacheVariantField + hex.EncodeToString(h.Sum(nil)[:16])
 }
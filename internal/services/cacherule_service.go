@@ -2,12 +2,26 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gateway/internal/models"
+	"net/http"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const cacheRuleColumns = `id, route_id, ttl_seconds, stale_while_revalidate_seconds, stale_if_error_seconds, cache_key_pattern, methods, negative_ttl_seconds, negative_status_codes, max_entry_bytes, enabled, org_id, user_id`
+
+// CacheableMethods are the methods a cache rule may cover. POST is for
+// lookups such as search or GraphQL queries and must be keyed on the body.
+var CacheableMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// DefaultNegativeStatusCodes are cached when negative caching is enabled
+// without a list of statuses.
+var DefaultNegativeStatusCodes = []int{http.StatusNotFound}
+
 type CacheRuleService struct {
 	db *pgxpool.Pool
 }
@@ -16,45 +30,143 @@ func NewCacheRuleService(db *pgxpool.Pool) *CacheRuleService {
 	return &CacheRuleService{db: db}
 }
 
-func (s *CacheRuleService) Create(ctx context.Context, userID string, req *models.CreateCacheRuleRequest) (*models.CacheRule, error) {
+func scanCacheRule(row pgx.Row) (*models.CacheRule, error) {
+	rule := &models.CacheRule{}
+	err := row.Scan(&rule.ID, &rule.RouteID, &rule.TTLSeconds, &rule.StaleWhileRevalidateSeconds, &rule.StaleIfErrorSeconds, &rule.CacheKeyPattern, &rule.Methods, &rule.NegativeTTLSeconds, &rule.NegativeStatusCodes, &rule.MaxEntryBytes, &rule.Enabled, &rule.OrgID, &rule.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// validateCacheRule checks the settings shared by Create and Update.
+func validateCacheRule(ttlSeconds, staleWhileRevalidateSeconds, staleIfErrorSeconds, negativeTTLSeconds int, pattern string, methods []string, negativeStatusCodes []int, maxEntryBytes int64) error {
+	if ttlSeconds < 0 || staleWhileRevalidateSeconds < 0 || staleIfErrorSeconds < 0 || negativeTTLSeconds < 0 {
+		return fmt.Errorf("%w: durations must not be negative", ErrInvalidCacheRule)
+	}
+	if maxEntryBytes < 0 {
+		return fmt.Errorf("%w: max_entry_bytes must not be negative", ErrInvalidCacheRule)
+	}
+	parsed, err := ParseCacheKeyPattern(pattern)
+	if err != nil {
+		return err
+	}
+	// Without the method in the key, a GET and an empty-body POST would
+	// share an entry and be answered with each other's response.
+	if len(methods) > 1 && !parsed.Includes("method") {
+		return fmt.Errorf("%w: caching more than one method requires method in cache_key_pattern", ErrInvalidCacheRule)
+	}
+	for _, method := range methods {
+		switch method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			if !parsed.Includes("body") {
+				return fmt.Errorf("%w: caching POST requires body in cache_key_pattern", ErrInvalidCacheRule)
+			}
+		default:
+			return fmt.Errorf("%w: method %q cannot be cached", ErrInvalidCacheRule, method)
+		}
+	}
+	for _, code := range negativeStatusCodes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("%w: negative_status_codes must be 4xx or 5xx, got %d", ErrInvalidCacheRule, code)
+		}
+	}
+	return nil
+}
+
+// normalizeCacheMethods upper-cases and de-duplicates methods, defaulting to
+// GET.
+func normalizeCacheMethods(methods []string) []string {
+	normalized := []string{}
+	seen := map[string]bool{}
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method != "" && !seen[method] {
+			seen[method] = true
+			normalized = append(normalized, method)
+		}
+	}
+	if len(normalized) == 0 {
+		normalized = append(normalized, http.MethodGet)
+	}
+	return normalized
+}
+
+func (s *CacheRuleService) Create(ctx context.Context, actor *models.Membership, req *models.CreateCacheRuleRequest, audit Auditor) (*models.CacheRule, error) {
+	if err := Authorize(actor, models.RoleEditor); err != nil {
+		return nil, err
+	}
+
 	if req.CacheKeyPattern == "" {
-		req.CacheKeyPattern = "*"
+		req.CacheKeyPattern = DefaultCacheKeyPattern
+	}
+	req.Methods = normalizeCacheMethods(req.Methods)
+	if req.NegativeStatusCodes == nil {
+		req.NegativeStatusCodes = DefaultNegativeStatusCodes
+	}
+	if err := validateCacheRule(req.TTLSeconds, req.StaleWhileRevalidateSeconds, req.StaleIfErrorSeconds, req.NegativeTTLSeconds, req.CacheKeyPattern, req.Methods, req.NegativeStatusCodes, req.MaxEntryBytes); err != nil {
+		return nil, err
 	}
 
-	// Verify that the route belongs to the user
-	var routeUserID string
-	err := s.db.QueryRow(ctx, `SELECT user_id FROM routes WHERE id = $1`, req.RouteID).Scan(&routeUserID)
+	// Verify that the route belongs to the organization
+	var routeOrgID *int64
+	err := s.db.QueryRow(ctx, `SELECT org_id FROM routes WHERE id = $1`, req.RouteID).Scan(&routeOrgID)
 	if err != nil {
 		return nil, fmt.Errorf("route not found: %w", err)
 	}
-	if routeUserID != userID {
-		return nil, fmt.Errorf("access denied: route does not belong to user")
+	if routeOrgID == nil || *routeOrgID != actor.OrgID {
+		return nil, fmt.Errorf("access denied: route does not belong to organization")
 	}
 
-	rule := &models.CacheRule{}
-	err = s.db.QueryRow(
-		ctx,
-		`INSERT INTO cache_rules (route_id, ttl_seconds, cache_key_pattern, enabled, user_id)
-		 VALUES ($1, $2, $3, $4, $5)
-		 RETURNING id, route_id, ttl_seconds, cache_key_pattern, enabled, user_id`,
-		req.RouteID, req.TTLSeconds, req.CacheKeyPattern, true, userID,
-	).Scan(&rule.ID, &rule.RouteID, &rule.TTLSeconds, &rule.CacheKeyPattern, &rule.Enabled, &rule.UserID)
-
+	var rule *models.CacheRule
+	err = withTx(ctx, s.db, func(tx pgx.Tx) error {
+		var err error
+		rule, err = scanCacheRule(tx.QueryRow(
+			ctx,
+			`INSERT INTO cache_rules (route_id, ttl_seconds, stale_while_revalidate_seconds, stale_if_error_seconds, cache_key_pattern, methods, negative_ttl_seconds, negative_status_codes, max_entry_bytes, enabled, org_id, user_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+			 RETURNING `+cacheRuleColumns,
+			req.RouteID, req.TTLSeconds, req.StaleWhileRevalidateSeconds, req.StaleIfErrorSeconds, req.CacheKeyPattern, req.Methods, req.NegativeTTLSeconds, req.NegativeStatusCodes, req.MaxEntryBytes, true, actor.OrgID, actor.UserID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to create cache rule: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, rule.ID, nil, rule)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cache rule: %w", err)
+		return nil, err
 	}
 
 	return rule, nil
 }
 
 func (s *CacheRuleService) GetByRouteID(ctx context.Context, routeID int64) (*models.CacheRule, error) {
-	rule := &models.CacheRule{}
-	err := s.db.QueryRow(
+	rule, err := scanCacheRule(s.db.QueryRow(
 		ctx,
-		`SELECT id, route_id, ttl_seconds, cache_key_pattern, enabled, user_id
+		`SELECT `+cacheRuleColumns+`
 		 FROM cache_rules WHERE route_id = $1 AND enabled = true`,
 		routeID,
-	).Scan(&rule.ID, &rule.RouteID, &rule.TTLSeconds, &rule.CacheKeyPattern, &rule.Enabled, &rule.UserID)
+	))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache rule: %w", err)
+	}
+
+	return rule, nil
+}
+
+func (s *CacheRuleService) GetByID(ctx context.Context, actor *models.Membership, id int64) (*models.CacheRule, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	rule, err := scanCacheRule(s.db.QueryRow(
+		ctx,
+		`SELECT `+cacheRuleColumns+`
+		 FROM cache_rules WHERE id = $1 AND org_id = $2`,
+		id, actor.OrgID,
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get cache rule: %w", err)
@@ -63,12 +175,16 @@ func (s *CacheRuleService) GetByRouteID(ctx context.Context, routeID int64) (*mo
 	return rule, nil
 }
 
-func (s *CacheRuleService) List(ctx context.Context, userID string) ([]*models.CacheRule, error) {
+func (s *CacheRuleService) List(ctx context.Context, actor *models.Membership) ([]*models.CacheRule, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
 	rows, err := s.db.Query(
 		ctx,
-		`SELECT id, route_id, ttl_seconds, cache_key_pattern, enabled, user_id
-		 FROM cache_rules WHERE user_id = $1 ORDER BY id DESC`,
-		userID,
+		`SELECT `+cacheRuleColumns+`
+		 FROM cache_rules WHERE org_id = $1 ORDER BY id DESC`,
+		actor.OrgID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list cache rules: %w", err)
@@ -77,8 +193,8 @@ func (s *CacheRuleService) List(ctx context.Context, userID string) ([]*models.C
 
 	rules := []*models.CacheRule{}
 	for rows.Next() {
-		rule := &models.CacheRule{}
-		if err := rows.Scan(&rule.ID, &rule.RouteID, &rule.TTLSeconds, &rule.CacheKeyPattern, &rule.Enabled, &rule.UserID); err != nil {
+		rule, err := scanCacheRule(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan cache rule: %w", err)
 		}
 		rules = append(rules, rule)
@@ -87,29 +203,75 @@ func (s *CacheRuleService) List(ctx context.Context, userID string) ([]*models.C
 	return rules, nil
 }
 
-func (s *CacheRuleService) Update(ctx context.Context, userID string, id int64, ttl int, enabled bool) (*models.CacheRule, error) {
-	rule := &models.CacheRule{}
-	err := s.db.QueryRow(
-		ctx,
-		`UPDATE cache_rules SET ttl_seconds = $1, enabled = $2 WHERE id = $3 AND user_id = $4
-		 RETURNING id, route_id, ttl_seconds, cache_key_pattern, enabled, user_id`,
-		ttl, enabled, id, userID,
-	).Scan(&rule.ID, &rule.RouteID, &rule.TTLSeconds, &rule.CacheKeyPattern, &rule.Enabled, &rule.UserID)
+func (s *CacheRuleService) Update(ctx context.Context, actor *models.Membership, id int64, req *models.UpdateCacheRuleRequest, audit Auditor) (*models.CacheRule, error) {
+	if err := Authorize(actor, models.RoleEditor); err != nil {
+		return nil, err
+	}
+	req.Methods = normalizeCacheMethods(req.Methods)
+	if req.NegativeStatusCodes == nil {
+		req.NegativeStatusCodes = DefaultNegativeStatusCodes
+	}
+
+	var rule *models.CacheRule
+	err := withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := scanCacheRule(tx.QueryRow(
+			ctx,
+			`SELECT `+cacheRuleColumns+`
+			 FROM cache_rules WHERE id = $1 AND org_id = $2 FOR UPDATE`,
+			id, actor.OrgID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to get cache rule: %w", err)
+		}
+
+		// Methods are validated against the pattern the rule will end up with.
+		pattern := req.CacheKeyPattern
+		if pattern == "" {
+			pattern = before.CacheKeyPattern
+		}
+		if err := validateCacheRule(req.TTLSeconds, req.StaleWhileRevalidateSeconds, req.StaleIfErrorSeconds, req.NegativeTTLSeconds, pattern, req.Methods, req.NegativeStatusCodes, req.MaxEntryBytes); err != nil {
+			return err
+		}
 
+		rule, err = scanCacheRule(tx.QueryRow(
+			ctx,
+			`UPDATE cache_rules
+			 SET ttl_seconds = $1, stale_while_revalidate_seconds = $2, stale_if_error_seconds = $3, enabled = $4,
+			     cache_key_pattern = $5, methods = $6, negative_ttl_seconds = $7, negative_status_codes = $8, max_entry_bytes = $9
+			 WHERE id = $10 AND org_id = $11
+			 RETURNING `+cacheRuleColumns,
+			req.TTLSeconds, req.StaleWhileRevalidateSeconds, req.StaleIfErrorSeconds, req.Enabled, pattern, req.Methods, req.NegativeTTLSeconds, req.NegativeStatusCodes, req.MaxEntryBytes, id, actor.OrgID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to update cache rule: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, rule)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update cache rule: %w", err)
+		return nil, err
 	}
 
 	return rule, nil
 }
 
-func (s *CacheRuleService) Delete(ctx context.Context, userID string, id int64) error {
-	result, err := s.db.Exec(ctx, `DELETE FROM cache_rules WHERE id = $1 AND user_id = $2`, id, userID)
-	if err != nil {
-		return fmt.Errorf("failed to delete cache rule: %w", err)
-	}
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("cache rule not found or access denied")
+func (s *CacheRuleService) Delete(ctx context.Context, actor *models.Membership, id int64, audit Auditor) error {
+	if err := Authorize(actor, models.RoleEditor); err != nil {
+		return err
 	}
-	return nil
+
+	return withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := scanCacheRule(tx.QueryRow(
+			ctx,
+			`DELETE FROM cache_rules WHERE id = $1 AND org_id = $2
+			 RETURNING `+cacheRuleColumns,
+			id, actor.OrgID,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("cache rule not found or access denied")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete cache rule: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, nil)
+	})
 }
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings the gateway can produce.
+const (
+	EncodingGzip   = "gzip"
+	EncodingZstd   = "zstd"
+	EncodingBrotli = "br"
+)
+
+// ResponseEncodings are the codings routes may compress responses with, in
+// the default order of preference.
+var ResponseEncodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+
+// The zstd encoder and decoder are safe for concurrent EncodeAll and
+// DecodeAll calls, so one of each is shared.
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil)
+	})
+)
+
+// ValidEncoding reports whether encoding is one cache entries can be stored
+// with.
+func ValidEncoding(encoding string) bool {
+	return encoding == EncodingGzip || encoding == EncodingZstd
+}
+
+// Compress encodes body with the given content coding.
+func Compress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, fmt.Errorf("failed to compress body: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress body: %w", err)
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+		}
+		return enc.EncodeAll(body, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// Decompress decodes a body produced by Compress.
+func Decompress(encoding string, body []byte) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress body: %w", err)
+		}
+		defer zr.Close()
+		decoded, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress body: %w", err)
+		}
+		return decoded, nil
+	case EncodingZstd:
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+		}
+		decoded, err := dec.DecodeAll(body, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress body: %w", err)
+		}
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// Encoder is a streaming compressor that can be reused for another output.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Response encoders hold sizeable buffers, so they are pooled per coding.
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+	EncodingBrotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, 5)
+	}},
+	EncodingZstd: {New: func() interface{} {
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil
+		}
+		return enc
+	}},
+}
+
+// AcquireEncoder returns a pooled encoder for the content coding writing to
+// w. It must be closed and then handed back with ReleaseEncoder.
+func AcquireEncoder(encoding string, w io.Writer) (Encoder, bool) {
+	pool, ok := encoderPools[encoding]
+	if !ok {
+		return nil, false
+	}
+	enc, ok := pool.Get().(Encoder)
+	if !ok {
+		return nil, false
+	}
+	enc.Reset(w)
+	return enc, true
+}
+
+func ReleaseEncoder(encoding string, enc Encoder) {
+	if pool, ok := encoderPools[encoding]; ok {
+		enc.Reset(nil)
+		pool.Put(enc)
+	}
+}
+
+// acceptedEncodings parses a request's Accept-Encoding into the weight of
+// each listed coding (RFC 9110, section 12.5.3).
+func acceptedEncodings(reqHeader http.Header) map[string]float64 {
+	weights := map[string]float64{}
+	for _, value := range reqHeader.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(item, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			q := 1.0
+			for _, param := range strings.Split(params, ";") {
+				key, arg, _ := strings.Cut(param, "=")
+				if strings.EqualFold(strings.TrimSpace(key), "q") {
+					if v, err := strconv.ParseFloat(strings.TrimSpace(arg), 64); err == nil {
+						q = v
+					}
+				}
+			}
+			weights[name] = q
+		}
+	}
+	return weights
+}
+
+// encodingWeight is the weight of a coding by name or, when it is not
+// listed, through "*".
+func encodingWeight(weights map[string]float64, encoding string) float64 {
+	if q, ok := weights[encoding]; ok {
+		return q
+	}
+	return weights["*"]
+}
+
+// AcceptsEncoding reports whether a request's Accept-Encoding allows the
+// content coding, either by name or through "*", and does not refuse it
+// with q=0.
+func AcceptsEncoding(reqHeader http.Header, encoding string) bool {
+	return encodingWeight(acceptedEncodings(reqHeader), encoding) > 0
+}
+
+// NegotiateEncoding picks the offered coding the request accepts with the
+// highest weight, preferring earlier offers on ties. It returns "" when the
+// request accepts none of them.
+func NegotiateEncoding(reqHeader http.Header, offered []string) string {
+	weights := acceptedEncodings(reqHeader)
+	best, bestQ := "", 0.0
+	for _, encoding := range offered {
+		if q := encodingWeight(weights, encoding); q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// hasVaryName reports whether a response's Vary header lists name, or "*".
+func hasVaryName(header http.Header, name string) bool {
+	for _, value := range header.Values("Vary") {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item == "*" || strings.EqualFold(item, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddVary adds name to a response's Vary header unless it is already
+// covered.
+func AddVary(header http.Header, name string) {
+	if !hasVaryName(header, name) {
+		header.Add("Vary", name)
+	}
+}
+
+// WeakETag turns a strong ETag into a weak one, for a representation that is
+// not byte-for-byte the one it was issued for.
+func WeakETag(etag string) string {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "W/" + etag
+}
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LocalCache is an in-process LRU of cached resources in front of Redis,
+// bounded by entry count and approximate size in bytes. Resources are kept
+// for a short TTL only, since other gateway instances may replace them in
+// Redis without notice; deletions are broadcast and applied immediately.
+type LocalCache struct {
+	mu         sync.Mutex
+	maxBytes   int64
+	maxEntries int
+	ttl        time.Duration
+	size       int64
+	order      *list.List // front is most recently used
+	items      map[string]*list.Element
+}
+
+// localResource mirrors one Redis cache hash: the Vary header names and the
+// representations read so far.
+type localResource struct {
+	key       string
+	vary      []string
+	variants  map[string]*CachedResponse
+	size      int64
+	expiresAt time.Time
+}
+
+// NewLocalCache returns nil, which disables the local tier, when maxBytes
+// or ttl is not positive.
+func NewLocalCache(maxBytes int64, maxEntries int, ttl time.Duration) *LocalCache {
+	if maxBytes <= 0 || ttl <= 0 {
+		return nil
+	}
+	if maxEntries < 1 {
+		maxEntries = 1
+	}
+	return &LocalCache{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		ttl:        ttl,
+		order:      list.New(),
+		items:      map[string]*list.Element{},
+	}
+}
+
+// get returns the representation of key matching the request headers.
+func (l *LocalCache) get(key string, reqHeader http.Header, now time.Time) (*CachedResponse, bool) {
+	if l == nil {
+		return nil, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	res := elem.Value.(*localResource)
+	if !now.Before(res.expiresAt) {
+		l.removeElement(elem)
+		return nil, false
+	}
+	entry, ok := res.variants[variantField(res.vary, reqHeader)]
+	if !ok {
+		return nil, false
+	}
+	l.order.MoveToFront(elem)
+	return entry, true
+}
+
+// add stores one representation read from Redis. A different Vary than the
+// one held locally replaces the resource.
+func (l *LocalCache) add(key string, vary []string, field string, entry *CachedResponse, now time.Time) {
+	if l == nil {
+		return
+	}
+	entrySize := cachedResponseSize(entry)
+	if entrySize > l.maxBytes {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.items[key]
+	if ok && !sameNames(elem.Value.(*localResource).vary, vary) {
+		l.removeElement(elem)
+		ok = false
+	}
+	if !ok {
+		res := &localResource{
+			key:       key,
+			vary:      vary,
+			variants:  map[string]*CachedResponse{},
+			size:      int64(len(key)),
+			expiresAt: now.Add(l.ttl),
+		}
+		elem = l.order.PushFront(res)
+		l.items[key] = elem
+		l.size += res.size
+	}
+
+	res := elem.Value.(*localResource)
+	if old, ok := res.variants[field]; ok {
+		res.size -= cachedResponseSize(old)
+		l.size -= cachedResponseSize(old)
+	}
+	res.variants[field] = entry
+	res.size += entrySize
+	l.size += entrySize
+	l.order.MoveToFront(elem)
+
+	for (l.size > l.maxBytes || l.order.Len() > l.maxEntries) && l.order.Len() > 0 {
+		l.removeElement(l.order.Back())
+	}
+}
+
+func (l *LocalCache) remove(keys ...string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := l.items[key]; ok {
+			l.removeElement(elem)
+		}
+	}
+}
+
+func (l *LocalCache) removeElement(elem *list.Element) {
+	res := l.order.Remove(elem).(*localResource)
+	delete(l.items, res.key)
+	l.size -= res.size
+}
+
+// cachedResponseSize approximates the memory held by an entry.
+func cachedResponseSize(entry *CachedResponse) int64 {
+	size := int64(len(entry.Body))
+	for name, values := range entry.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+	return size
+}
+
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NonceCache remembers nonces of signed requests so that a captured request
+// cannot be replayed while its timestamp is still inside the skew window.
+type NonceCache struct {
+	client *redis.Client
+}
+
+func NewNonceCache(client *redis.Client) *NonceCache {
+	return &NonceCache{client: client}
+}
+
+// Claim records the nonce and reports whether it was unused.
+func (n *NonceCache) Claim(ctx context.Context, scope, nonce string, ttl time.Duration) (bool, error) {
+	ok, err := n.client.SetNX(ctx, fmt.Sprintf("nonce:%s:%s", scope, nonce), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim nonce: %w", err)
+	}
+	return ok, nil
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gateway/internal/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrForbidden         = errors.New("insufficient role")
+	ErrNotMember         = errors.New("not a member of this organization")
+	ErrInvalidMembership = errors.New("invalid membership")
+)
+
+var roleRanks = map[string]int{
+	models.RoleViewer: 1,
+	models.RoleEditor: 2,
+	models.RoleAdmin:  3,
+	models.RoleOwner:  4,
+}
+
+// Authorize checks that the member's role is at least role.
+func Authorize(member *models.Membership, role string) error {
+	if member == nil || roleRanks[member.Role] < roleRanks[role] {
+		return ErrForbidden
+	}
+	return nil
+}
+
+type OrgService struct {
+	db *pgxpool.Pool
+}
+
+func NewOrgService(db *pgxpool.Pool) *OrgService {
+	return &OrgService{db: db}
+}
+
+func scanMembership(row pgx.Row) (*models.Membership, error) {
+	member := &models.Membership{}
+	err := row.Scan(&member.OrgID, &member.UserID, &member.Role, &member.Personal, &member.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// GetMembership returns the user's membership in an organization, or
+// ErrNotMember.
+func (s *OrgService) GetMembership(ctx context.Context, orgID int64, userID string) (*models.Membership, error) {
+	member, err := scanMembership(s.db.QueryRow(
+		ctx,
+		`SELECT m.org_id, m.user_id, m.role, o.personal_user_id IS NOT NULL, m.created_at
+		 FROM org_members m JOIN organizations o ON o.id = m.org_id
+		 WHERE m.org_id = $1 AND m.user_id = $2`,
+		orgID, userID,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get membership: %w", err)
+	}
+	return member, nil
+}
+
+// EnsurePersonalOrg returns the user's membership in their personal
+// organization, creating it on first use.
+func (s *OrgService) EnsurePersonalOrg(ctx context.Context, userID string) (*models.Membership, error) {
+	member, err := scanMembership(s.db.QueryRow(
+		ctx,
+		`SELECT m.org_id, m.user_id, m.role, true, m.created_at
+		 FROM org_members m JOIN organizations o ON o.id = m.org_id
+		 WHERE o.personal_user_id = $1 AND m.user_id = $1`,
+		userID,
+	))
+	if err == nil {
+		return member, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get personal organization: %w", err)
+	}
+
+	// The no-op updates make RETURNING yield the existing rows when another
+	// request created the organization concurrently.
+	member, err = scanMembership(s.db.QueryRow(
+		ctx,
+		`WITH org AS (
+			INSERT INTO organizations (name, personal_user_id) VALUES ($1, $1)
+			ON CONFLICT (personal_user_id) DO UPDATE SET personal_user_id = EXCLUDED.personal_user_id
+			RETURNING id
+		 )
+		 INSERT INTO org_members (org_id, user_id, role)
+		 SELECT id, $1, $2 FROM org
+		 ON CONFLICT (org_id, user_id) DO UPDATE SET role = org_members.role
+		 RETURNING org_id, user_id, role, true, created_at`,
+		userID, models.RoleOwner,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create personal organization: %w", err)
+	}
+	return member, nil
+}
+
+// Create makes a new organization owned by the user.
+func (s *OrgService) Create(ctx context.Context, userID string, req *models.CreateOrganizationRequest, audit Auditor) (*models.Organization, error) {
+	if req.Name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidMembership)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	org := &models.Organization{Role: models.RoleOwner}
+	err = tx.QueryRow(
+		ctx,
+		`INSERT INTO organizations (name) VALUES ($1) RETURNING id, name, created_at`,
+		req.Name,
+	).Scan(&org.ID, &org.Name, &org.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)`, org.ID, userID, models.RoleOwner); err != nil {
+		return nil, fmt.Errorf("failed to add organization owner: %w", err)
+	}
+
+	if err := audit(ctx, tx, org.ID, org.ID, nil, org); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit organization: %w", err)
+	}
+	return org, nil
+}
+
+// ListForUser returns every organization the user belongs to, with their role.
+func (s *OrgService) ListForUser(ctx context.Context, userID string) ([]*models.Organization, error) {
+	rows, err := s.db.Query(
+		ctx,
+		`SELECT o.id, o.name, o.personal_user_id IS NOT NULL, m.role, o.created_at
+		 FROM organizations o JOIN org_members m ON m.org_id = o.id
+		 WHERE m.user_id = $1 ORDER BY o.created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+	defer rows.Close()
+
+	orgs := []*models.Organization{}
+	for rows.Next() {
+		org := &models.Organization{}
+		if err := rows.Scan(&org.ID, &org.Name, &org.Personal, &org.Role, &org.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan organization: %w", err)
+		}
+		orgs = append(orgs, org)
+	}
+
+	return orgs, nil
+}
+
+func (s *OrgService) ListMembers(ctx context.Context, actor *models.Membership) ([]*models.Membership, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(
+		ctx,
+		`SELECT m.org_id, m.user_id, m.role, o.personal_user_id IS NOT NULL, m.created_at
+		 FROM org_members m JOIN organizations o ON o.id = m.org_id
+		 WHERE m.org_id = $1 ORDER BY m.created_at`,
+		actor.OrgID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members: %w", err)
+	}
+	defer rows.Close()
+
+	members := []*models.Membership{}
+	for rows.Next() {
+		member, err := scanMembership(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan member: %w", err)
+		}
+		members = append(members, member)
+	}
+
+	return members, nil
+}
+
+// AddMember adds a user to the actor's organization. Admins may add anyone
+// below owner; only owners may add owners.
+func (s *OrgService) AddMember(ctx context.Context, actor *models.Membership, req *models.AddMemberRequest, audit Auditor) (*models.Membership, error) {
+	if err := authorizeRoleChange(actor, req.Role); err != nil {
+		return nil, err
+	}
+	if req.UserID == "" {
+		return nil, fmt.Errorf("%w: user_id is required", ErrInvalidMembership)
+	}
+	if actor.Personal {
+		return nil, fmt.Errorf("%w: personal organizations cannot have other members", ErrInvalidMembership)
+	}
+
+	var member *models.Membership
+	err := withTx(ctx, s.db, func(tx pgx.Tx) error {
+		var err error
+		member, err = scanMembership(tx.QueryRow(
+			ctx,
+			`INSERT INTO org_members (org_id, user_id, role) VALUES ($1, $2, $3)
+			 ON CONFLICT (org_id, user_id) DO NOTHING
+			 RETURNING org_id, user_id, role, false, created_at`,
+			actor.OrgID, req.UserID, req.Role,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("%w: user is already a member", ErrInvalidMembership)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add member: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, member.UserID, nil, member)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// UpdateMemberRole changes a member's role. Changes that grant or remove the
+// owner role require an owner, and the last owner cannot be demoted.
+func (s *OrgService) UpdateMemberRole(ctx context.Context, actor *models.Membership, userID string, req *models.UpdateMemberRequest, audit Auditor) (*models.Membership, error) {
+	if err := authorizeRoleChange(actor, req.Role); err != nil {
+		return nil, err
+	}
+
+	var member *models.Membership
+	err := s.withOwnerCheck(ctx, actor, userID, audit, func(tx pgx.Tx, current *models.Membership) (*models.Membership, error) {
+		if current.Role == models.RoleOwner && actor.Role != models.RoleOwner {
+			return nil, ErrForbidden
+		}
+
+		var err error
+		member, err = scanMembership(tx.QueryRow(
+			ctx,
+			`UPDATE org_members SET role = $1 WHERE org_id = $2 AND user_id = $3
+			 RETURNING org_id, user_id, role, $4::boolean, created_at`,
+			req.Role, actor.OrgID, userID, actor.Personal,
+		))
+		return member, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveMember removes a user from the actor's organization. Any member may
+// remove themselves; removing others requires admin, or owner for owners.
+func (s *OrgService) RemoveMember(ctx context.Context, actor *models.Membership, userID string, audit Auditor) error {
+	if userID != actor.UserID {
+		if err := Authorize(actor, models.RoleAdmin); err != nil {
+			return err
+		}
+	}
+
+	return s.withOwnerCheck(ctx, actor, userID, audit, func(tx pgx.Tx, current *models.Membership) (*models.Membership, error) {
+		if current.Role == models.RoleOwner && actor.Role != models.RoleOwner {
+			return nil, ErrForbidden
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM org_members WHERE org_id = $1 AND user_id = $2`, actor.OrgID, userID)
+		return nil, err
+	})
+}
+
+// withOwnerCheck runs change against a member of the actor's organization
+// with the organization locked, and rolls it back if no owner would remain.
+// change returns the member as it ends up, or nil if it was removed.
+func (s *OrgService) withOwnerCheck(ctx context.Context, actor *models.Membership, userID string, audit Auditor, change func(pgx.Tx, *models.Membership) (*models.Membership, error)) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `SELECT id FROM organizations WHERE id = $1 FOR UPDATE`, actor.OrgID); err != nil {
+		return fmt.Errorf("failed to lock organization: %w", err)
+	}
+
+	current, err := scanMembership(tx.QueryRow(
+		ctx,
+		`SELECT org_id, user_id, role, $3::boolean, created_at FROM org_members WHERE org_id = $1 AND user_id = $2`,
+		actor.OrgID, userID, actor.Personal,
+	))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotMember
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get member: %w", err)
+	}
+
+	updated, err := change(tx, current)
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return err
+		}
+		return fmt.Errorf("failed to update member: %w", err)
+	}
+
+	var owners int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM org_members WHERE org_id = $1 AND role = $2`, actor.OrgID, models.RoleOwner).Scan(&owners); err != nil {
+		return fmt.Errorf("failed to count owners: %w", err)
+	}
+	if owners == 0 {
+		return fmt.Errorf("%w: an organization must keep at least one owner", ErrInvalidMembership)
+	}
+
+	if err := audit(ctx, tx, actor.OrgID, userID, current, updated); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit member change: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the actor's organization and everything it owns. Personal
+// organizations cannot be deleted.
+func (s *OrgService) Delete(ctx context.Context, actor *models.Membership, audit Auditor) error {
+	if err := Authorize(actor, models.RoleOwner); err != nil {
+		return err
+	}
+	if actor.Personal {
+		return fmt.Errorf("%w: personal organizations cannot be deleted", ErrInvalidMembership)
+	}
+
+	return withTx(ctx, s.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM organizations WHERE id = $1`, actor.OrgID); err != nil {
+			return fmt.Errorf("failed to delete organization: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, actor.OrgID, map[string]int64{"id": actor.OrgID}, nil)
+	})
+}
+
+func authorizeRoleChange(actor *models.Membership, role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("%w: unknown role %q", ErrInvalidMembership, role)
+	}
+	if role == models.RoleOwner {
+		return Authorize(actor, models.RoleOwner)
+	}
+	return Authorize(actor, models.RoleAdmin)
+}
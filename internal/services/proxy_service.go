@@ -30,7 +30,7 @@ func NewProxyService() *ProxyService {
 	}
 }
 
-func (p *ProxyService) Forward(ctx context.Context, backendURLs []string, method, originalPath, routePath string, headers http.Header, body []byte, timeoutMs int) (*http.Response, error) {
+func (p *ProxyService) Forward(ctx context.Context, backendURLs []string, method, originalPath, rawQuery, routePath string, headers http.Header, body []byte, timeoutMs int) (*http.Response, error) {
 	if len(backendURLs) == 0 {
 		return nil, fmt.Errorf("no backend URLs configured")
 	}
@@ -55,6 +55,9 @@ func (p *ProxyService) Forward(ctx context.Context, backendURLs []string, method
 	}
 
 	finalURL := backendURL + trimmed
+	if rawQuery != "" {
+		finalURL += "?" + rawQuery
+	}
 
 	req, err := http.NewRequestWithContext(ctx, method, finalURL, bytes.NewReader(body))
 	if err != nil {
@@ -66,7 +69,11 @@ func (p *ProxyService) Forward(ctx context.Context, backendURLs []string, method
 			req.Header.Add(key, value)
 		}
 	}
-	req.Header.Set("Accept", "application/json")
+	// Keep the client's Accept so the backend can negotiate, and responses
+	// that vary on it are stored per representation.
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -33,6 +33,12 @@ func (r *RateLimiter) Allow(ctx context.Context, key string, limit int) (bool, e
 	return count <= int64(limit), nil
 }
 
+// ResetAt returns when the current one-minute window ends and counts start
+// over.
+func (r *RateLimiter) ResetAt() time.Time {
+	return time.Now().Truncate(time.Minute).Add(time.Minute)
+}
+
 func (r *RateLimiter) GetCount(ctx context.Context, key string) (int64, error) {
 	now := time.Now()
 	windowStart := now.Truncate(time.Minute)
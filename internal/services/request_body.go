@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/json"
+	"gateway/internal/models"
+	"net/http"
+	"strings"
+)
+
+// BodyError rejects a request body before it is forwarded. Reason is a
+// stable code recorded with the analytics event.
+type BodyError struct {
+	StatusCode int
+	Reason     string
+	Message    string
+}
+
+func (e *BodyError) Error() string {
+	return e.Message
+}
+
+var (
+	ErrBodyTooLarge           = &BodyError{StatusCode: http.StatusRequestEntityTooLarge, Reason: "body_too_large", Message: "request body too large"}
+	ErrUnsupportedContentType = &BodyError{StatusCode: http.StatusUnsupportedMediaType, Reason: "unsupported_content_type", Message: "content type is not allowed for this route"}
+	ErrMalformedJSON          = &BodyError{StatusCode: http.StatusBadRequest, Reason: "malformed_json", Message: "request body is not valid JSON"}
+)
+
+// RequestBodyLimit is the largest request body a route accepts: its own
+// max_body_bytes, or defaultMax when it sets none. Zero means no limit.
+func RequestBodyLimit(route *models.Route, defaultMax int64) int64 {
+	if route != nil && route.MaxBodyBytes > 0 {
+		return route.MaxBodyBytes
+	}
+	return defaultMax
+}
+
+// CheckRequestBody enforces a route's content types and JSON validation on a
+// non-empty request body.
+func CheckRequestBody(route *models.Route, contentType string, body []byte) *BodyError {
+	if len(body) == 0 {
+		return nil
+	}
+	if len(route.AllowedContentTypes) > 0 && !MatchMediaType(contentType, route.AllowedContentTypes) {
+		return ErrUnsupportedContentType
+	}
+	if route.ValidateJSON && isJSONMediaType(contentType) && !json.Valid(body) {
+		return ErrMalformedJSON
+	}
+	return nil
+}
+
+// MatchMediaType reports whether the media type of a Content-Type value is
+// one of patterns, exactly or through a "type/*" wildcard. Patterns must be
+// lower case.
+func MatchMediaType(contentType string, patterns []string) bool {
+	mediaType := mediaTypeOf(contentType)
+	if mediaType == "" {
+		return false
+	}
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, pattern := range patterns {
+		if pattern == mediaType || pattern == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// isJSONMediaType matches application/json and structured syntax types such
+// as application/problem+json.
+func isJSONMediaType(contentType string) bool {
+	mediaType := mediaTypeOf(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// validMediaRange accepts a lower-case media type or "type/*" wildcard.
+func validMediaRange(mediaRange string) bool {
+	major, minor, ok := strings.Cut(mediaRange, "/")
+	return ok && major != "" && major != "*" && minor != "" && !strings.ContainsAny(mediaRange, " ;,")
+}
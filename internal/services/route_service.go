@@ -2,12 +2,30 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"gateway/internal/models"
+	"net/url"
+	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+const routeColumns = `id, path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, shared_with_org_ids, key_location, key_name, auth_mode, jwt_config, compression, max_body_bytes, allowed_content_types, validate_json, org_id, user_id, created_at`
+
+var ErrInvalidRoute = errors.New("invalid route")
+
+// ReservedPathPrefix is served by the gateway itself and never proxied.
+const ReservedPathPrefix = "/_gateway"
+
+// Defaults for settings a route's compression policy leaves out.
+const DefaultCompressMinBytes = 1024
+
+var DefaultCompressContentTypes = []string{
+	"text/*", "application/json", "application/javascript", "application/xml", "image/svg+xml",
+}
+
 type RouteService struct {
 	db *pgxpool.Pool
 }
@@ -16,38 +34,79 @@ func NewRouteService(db *pgxpool.Pool) *RouteService {
 	return &RouteService{db: db}
 }
 
-func (s *RouteService) Create(ctx context.Context, userID string, req *models.CreateRouteRequest) (*models.Route, error) {
+func scanRoute(row pgx.Row) (*models.Route, error) {
+	route := &models.Route{}
+	err := row.Scan(&route.ID, &route.Path, &route.BackendURLs, &route.LoadBalancingStrategy, &route.TimeoutMs, &route.RetryCount, &route.SharedWithOrgIDs, &route.KeyLocation, &route.KeyName, &route.AuthMode, &route.JWTConfig, &route.Compression, &route.MaxBodyBytes, &route.AllowedContentTypes, &route.ValidateJSON, &route.OrgID, &route.UserID, &route.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+func (s *RouteService) Create(ctx context.Context, actor *models.Membership, req *models.CreateRouteRequest, audit Auditor) (*models.Route, error) {
+	if err := Authorize(actor, models.RoleEditor); err != nil {
+		return nil, err
+	}
 	if req.LoadBalancingStrategy == "" {
 		req.LoadBalancingStrategy = "round-robin"
 	}
 	if req.TimeoutMs == 0 {
 		req.TimeoutMs = 30000
 	}
+	if req.SharedWithOrgIDs == nil {
+		req.SharedWithOrgIDs = []int64{}
+	}
+	if req.AuthMode == "" {
+		req.AuthMode = models.AuthModeAPIKey
+	}
+	if req.Path == ReservedPathPrefix || strings.HasPrefix(req.Path, ReservedPathPrefix+"/") {
+		return nil, fmt.Errorf("%w: paths under %s are reserved", ErrInvalidRoute, ReservedPathPrefix)
+	}
+	if err := validateRouteAuth(req.KeyLocation, req.AuthMode, req.JWTConfig); err != nil {
+		return nil, err
+	}
+	if err := validateRouteCompression(req.Compression); err != nil {
+		return nil, err
+	}
+	if req.AllowedContentTypes == nil {
+		req.AllowedContentTypes = []string{}
+	}
+	if err := validateRouteBody(req.MaxBodyBytes, req.AllowedContentTypes); err != nil {
+		return nil, err
+	}
 
-	route := &models.Route{}
-	err := s.db.QueryRow(
-		ctx,
-		`INSERT INTO routes (path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, user_id)
-		 VALUES ($1, $2, $3, $4, $5, $6)
-		 RETURNING id, path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, user_id, created_at`,
-		req.Path, req.BackendURLs, req.LoadBalancingStrategy, req.TimeoutMs, req.RetryCount, userID,
-	).Scan(&route.ID, &route.Path, &route.BackendURLs, &route.LoadBalancingStrategy, &route.TimeoutMs, &route.RetryCount, &route.UserID, &route.CreatedAt)
-
+	var route *models.Route
+	err := withTx(ctx, s.db, func(tx pgx.Tx) error {
+		if err := validateSharedOrgs(ctx, tx, req.SharedWithOrgIDs); err != nil {
+			return err
+		}
+		var err error
+		route, err = scanRoute(tx.QueryRow(
+			ctx,
+			`INSERT INTO routes (path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, shared_with_org_ids, key_location, key_name, auth_mode, jwt_config, compression, max_body_bytes, allowed_content_types, validate_json, org_id, user_id)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			 RETURNING `+routeColumns,
+			req.Path, req.BackendURLs, req.LoadBalancingStrategy, req.TimeoutMs, req.RetryCount, req.SharedWithOrgIDs, req.KeyLocation, req.KeyName, req.AuthMode, req.JWTConfig, req.Compression, req.MaxBodyBytes, req.AllowedContentTypes, req.ValidateJSON, actor.OrgID, actor.UserID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to create route: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, route.ID, nil, route)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create route: %w", err)
+		return nil, err
 	}
 
 	return route, nil
 }
 
 func (s *RouteService) GetByPath(ctx context.Context, path string) (*models.Route, error) {
-	route := &models.Route{}
-	err := s.db.QueryRow(
+	route, err := scanRoute(s.db.QueryRow(
 		ctx,
-		`SELECT id, path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, user_id, created_at
+		`SELECT `+routeColumns+`
 		 FROM routes WHERE path = $1`,
 		path,
-	).Scan(&route.ID, &route.Path, &route.BackendURLs, &route.LoadBalancingStrategy, &route.TimeoutMs, &route.RetryCount, &route.UserID, &route.CreatedAt)
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get route: %w", err)
@@ -56,14 +115,36 @@ func (s *RouteService) GetByPath(ctx context.Context, path string) (*models.Rout
 	return route, nil
 }
 
-func (s *RouteService) GetByID(ctx context.Context, userID string, id int64) (*models.Route, error) {
-	route := &models.Route{}
-	err := s.db.QueryRow(
+// Resolve finds the route serving a request path, also trying the path with
+// a leading /api/, /v1/ or /v2/ prefix removed.
+func (s *RouteService) Resolve(ctx context.Context, path string) (*models.Route, error) {
+	route, err := s.GetByPath(ctx, path)
+	if err == nil {
+		return route, nil
+	}
+
+	for _, prefix := range []string{"/api/", "/v1/", "/v2/"} {
+		if strings.HasPrefix(path, prefix) {
+			if route, err := s.GetByPath(ctx, "/"+strings.TrimPrefix(path, prefix)); err == nil {
+				return route, nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+func (s *RouteService) GetByID(ctx context.Context, actor *models.Membership, id int64) (*models.Route, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
+	route, err := scanRoute(s.db.QueryRow(
 		ctx,
-		`SELECT id, path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, user_id, created_at
-		 FROM routes WHERE id = $1 AND user_id = $2`,
-		id, userID,
-	).Scan(&route.ID, &route.Path, &route.BackendURLs, &route.LoadBalancingStrategy, &route.TimeoutMs, &route.RetryCount, &route.UserID, &route.CreatedAt)
+		`SELECT `+routeColumns+`
+		 FROM routes WHERE id = $1 AND org_id = $2`,
+		id, actor.OrgID,
+	))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to get route: %w", err)
@@ -72,12 +153,16 @@ func (s *RouteService) GetByID(ctx context.Context, userID string, id int64) (*m
 	return route, nil
 }
 
-func (s *RouteService) List(ctx context.Context, userID string) ([]*models.Route, error) {
+func (s *RouteService) List(ctx context.Context, actor *models.Membership) ([]*models.Route, error) {
+	if err := Authorize(actor, models.RoleViewer); err != nil {
+		return nil, err
+	}
+
 	rows, err := s.db.Query(
 		ctx,
-		`SELECT id, path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, user_id, created_at
-		 FROM routes WHERE user_id = $1 ORDER BY created_at DESC`,
-		userID,
+		`SELECT `+routeColumns+`
+		 FROM routes WHERE org_id = $1 ORDER BY created_at DESC`,
+		actor.OrgID,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list routes: %w", err)
@@ -86,8 +171,8 @@ func (s *RouteService) List(ctx context.Context, userID string) ([]*models.Route
 
 	routes := []*models.Route{}
 	for rows.Next() {
-		route := &models.Route{}
-		if err := rows.Scan(&route.ID, &route.Path, &route.BackendURLs, &route.LoadBalancingStrategy, &route.TimeoutMs, &route.RetryCount, &route.UserID, &route.CreatedAt); err != nil {
+		route, err := scanRoute(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan route: %w", err)
 		}
 		routes = append(routes, route)
@@ -96,31 +181,196 @@ func (s *RouteService) List(ctx context.Context, userID string) ([]*models.Route
 	return routes, nil
 }
 
-func (s *RouteService) Update(ctx context.Context, userID string, id int64, req *models.UpdateRouteRequest) (*models.Route, error) {
-	route := &models.Route{}
-	err := s.db.QueryRow(
-		ctx,
-		`UPDATE routes 
-		 SET backend_urls = $1, load_balancing_strategy = $2, timeout_ms = $3, retry_count = $4
-		 WHERE id = $5 AND user_id = $6
-		 RETURNING id, path, backend_urls, load_balancing_strategy, timeout_ms, retry_count, user_id, created_at`,
-		req.BackendURLs, req.LoadBalancingStrategy, req.TimeoutMs, req.RetryCount, id, userID,
-	).Scan(&route.ID, &route.Path, &route.BackendURLs, &route.LoadBalancingStrategy, &route.TimeoutMs, &route.RetryCount, &route.UserID, &route.CreatedAt)
+func (s *RouteService) Update(ctx context.Context, actor *models.Membership, id int64, req *models.UpdateRouteRequest, audit Auditor) (*models.Route, error) {
+	if err := Authorize(actor, models.RoleEditor); err != nil {
+		return nil, err
+	}
+	if req.SharedWithOrgIDs == nil {
+		req.SharedWithOrgIDs = []int64{}
+	}
+	if req.AuthMode == "" {
+		req.AuthMode = models.AuthModeAPIKey
+	}
+	if err := validateRouteAuth(req.KeyLocation, req.AuthMode, req.JWTConfig); err != nil {
+		return nil, err
+	}
+	if err := validateRouteCompression(req.Compression); err != nil {
+		return nil, err
+	}
+	if req.AllowedContentTypes == nil {
+		req.AllowedContentTypes = []string{}
+	}
+	if err := validateRouteBody(req.MaxBodyBytes, req.AllowedContentTypes); err != nil {
+		return nil, err
+	}
+
+	var route *models.Route
+	err := withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := scanRoute(tx.QueryRow(
+			ctx,
+			`SELECT `+routeColumns+`
+			 FROM routes WHERE id = $1 AND org_id = $2 FOR UPDATE`,
+			id, actor.OrgID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to update route: %w", err)
+		}
+		if err := validateSharedOrgs(ctx, tx, req.SharedWithOrgIDs); err != nil {
+			return err
+		}
 
+		route, err = scanRoute(tx.QueryRow(
+			ctx,
+			`UPDATE routes
+			 SET backend_urls = $1, load_balancing_strategy = $2, timeout_ms = $3, retry_count = $4, shared_with_org_ids = $5,
+			     key_location = $6, key_name = $7, auth_mode = $8, jwt_config = $9, compression = $10,
+			     max_body_bytes = $11, allowed_content_types = $12, validate_json = $13
+			 WHERE id = $14 AND org_id = $15
+			 RETURNING `+routeColumns,
+			req.BackendURLs, req.LoadBalancingStrategy, req.TimeoutMs, req.RetryCount, req.SharedWithOrgIDs, req.KeyLocation, req.KeyName, req.AuthMode, req.JWTConfig, req.Compression, req.MaxBodyBytes, req.AllowedContentTypes, req.ValidateJSON, id, actor.OrgID,
+		))
+		if err != nil {
+			return fmt.Errorf("failed to update route: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, route)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to update route: %w", err)
+		return nil, err
 	}
 
 	return route, nil
 }
 
-func (s *RouteService) Delete(ctx context.Context, userID string, id int64) error {
-	result, err := s.db.Exec(ctx, `DELETE FROM routes WHERE id = $1 AND user_id = $2`, id, userID)
+// validateSharedOrgs rejects shared_with_org_ids naming organizations that
+// do not exist, so that a mistyped ID cannot grant access to an organization
+// created later.
+func validateSharedOrgs(ctx context.Context, tx pgx.Tx, orgIDs []int64) error {
+	if len(orgIDs) == 0 {
+		return nil
+	}
+	rows, err := tx.Query(ctx, `SELECT id FROM organizations WHERE id = ANY($1)`, orgIDs)
 	if err != nil {
-		return fmt.Errorf("failed to delete route: %w", err)
+		return fmt.Errorf("failed to check shared organizations: %w", err)
+	}
+	defer rows.Close()
+
+	found := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to check shared organizations: %w", err)
+		}
+		found[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to check shared organizations: %w", err)
+	}
+	for _, id := range orgIDs {
+		if !found[id] {
+			return fmt.Errorf("%w: unknown organization %d in shared_with_org_ids", ErrInvalidRoute, id)
+		}
+	}
+	return nil
+}
+
+func (s *RouteService) Delete(ctx context.Context, actor *models.Membership, id int64, audit Auditor) error {
+	if err := Authorize(actor, models.RoleEditor); err != nil {
+		return err
+	}
+
+	return withTx(ctx, s.db, func(tx pgx.Tx) error {
+		before, err := scanRoute(tx.QueryRow(
+			ctx,
+			`DELETE FROM routes WHERE id = $1 AND org_id = $2
+			 RETURNING `+routeColumns,
+			id, actor.OrgID,
+		))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("route not found or access denied")
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete route: %w", err)
+		}
+		return audit(ctx, tx, actor.OrgID, id, before, nil)
+	})
+}
+
+func validateRouteAuth(keyLocation, authMode string, jwtConfig *models.JWTConfig) error {
+	switch keyLocation {
+	case "", models.KeyLocationBearer, models.KeyLocationHeader, models.KeyLocationQuery, models.KeyLocationCookie:
+	default:
+		return fmt.Errorf("%w: unknown key_location %q", ErrInvalidRoute, keyLocation)
+	}
+
+	switch authMode {
+	case models.AuthModeAPIKey, models.AuthModeHMAC:
+	case models.AuthModeJWT:
+		if jwtConfig == nil || jwtConfig.Issuer == "" || jwtConfig.JWKSURL == "" {
+			return fmt.Errorf("%w: jwt auth requires jwt_config with issuer and jwks_url", ErrInvalidRoute)
+		}
+		// Keys fetched over plain HTTP could be swapped in transit to forge
+		// tokens.
+		if u, err := url.Parse(jwtConfig.JWKSURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%w: jwks_url must be an https URL", ErrInvalidRoute)
+		}
+	default:
+		return fmt.Errorf("%w: unknown auth_mode %q", ErrInvalidRoute, authMode)
+	}
+
+	return nil
+}
+
+// validateRouteCompression checks a compression policy and fills in the
+// defaults for settings it leaves out, so that routes show what applies.
+func validateRouteCompression(cfg *models.CompressionConfig) error {
+	if cfg == nil {
+		return nil
+	}
+
+	if len(cfg.Encodings) == 0 {
+		cfg.Encodings = append([]string{}, ResponseEncodings...)
+	}
+	for i, encoding := range cfg.Encodings {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding != EncodingGzip && encoding != EncodingBrotli && encoding != EncodingZstd {
+			return fmt.Errorf("%w: unknown compression encoding %q", ErrInvalidRoute, encoding)
+		}
+		cfg.Encodings[i] = encoding
+	}
+
+	if cfg.MinBytes < 0 {
+		return fmt.Errorf("%w: compression min_bytes must not be negative", ErrInvalidRoute)
+	}
+	if cfg.MinBytes == 0 {
+		cfg.MinBytes = DefaultCompressMinBytes
+	}
+
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = append([]string{}, DefaultCompressContentTypes...)
+	}
+	for i, contentType := range cfg.ContentTypes {
+		contentType = strings.ToLower(strings.TrimSpace(contentType))
+		if !validMediaRange(contentType) {
+			return fmt.Errorf("%w: invalid compression content type %q", ErrInvalidRoute, contentType)
+		}
+		cfg.ContentTypes[i] = contentType
 	}
-	if result.RowsAffected() == 0 {
-		return fmt.Errorf("route not found or access denied")
+
+	return nil
+}
+
+// validateRouteBody checks a route's request body limits, lower-casing the
+// allowed content types.
+func validateRouteBody(maxBodyBytes int64, allowedContentTypes []string) error {
+	if maxBodyBytes < 0 {
+		return fmt.Errorf("%w: max_body_bytes must not be negative", ErrInvalidRoute)
+	}
+	for i, contentType := range allowedContentTypes {
+		contentType = strings.ToLower(strings.TrimSpace(contentType))
+		if !validMediaRange(contentType) {
+			return fmt.Errorf("%w: invalid allowed content type %q", ErrInvalidRoute, contentType)
+		}
+		allowedContentTypes[i] = contentType
 	}
 	return nil
 }
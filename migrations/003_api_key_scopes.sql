@@ -0,0 +1,9 @@
+-- Scope API keys to specific routes, path patterns and HTTP methods.
+-- An empty array means "no restriction" for that dimension.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_route_ids BIGINT[] NOT NULL DEFAULT '{}';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_paths TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_methods TEXT[] NOT NULL DEFAULT '{}';
+
+-- Routes are only reachable by API keys of their owner, unless the owner
+-- explicitly shares the route with other users.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS shared_with TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,8 @@
+-- Optional network and browser restrictions for API keys.
+-- An empty array means the key may be used from anywhere.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_referrers TEXT[] NOT NULL DEFAULT '{}';
+
+-- Why a request was rejected before reaching a backend (e.g. ip_not_allowed).
+ALTER TABLE analytics_events ADD COLUMN IF NOT EXISTS reason VARCHAR(100);
+CREATE INDEX IF NOT EXISTS idx_analytics_events_reason ON analytics_events(reason) WHERE reason IS NOT NULL;
@@ -0,0 +1,4 @@
+-- Where the proxy looks for the API key on a route: bearer, header, query or
+-- cookie. An empty location falls back to the gateway-wide API_KEY_LOCATION.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS key_location VARCHAR(20) NOT NULL DEFAULT '';
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS key_name VARCHAR(100) NOT NULL DEFAULT '';
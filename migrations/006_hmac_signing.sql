@@ -0,0 +1,5 @@
+-- Per-route authentication mode: api_key (default) or hmac.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS auth_mode VARCHAR(20) NOT NULL DEFAULT 'api_key';
+
+-- Shared secret used to verify HMAC-signed requests made with this key.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS signing_secret VARCHAR(100);
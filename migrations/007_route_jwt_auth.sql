@@ -0,0 +1,3 @@
+-- Per-route JWT validation for end-user tokens (auth_mode = 'jwt'):
+-- issuer, audience, JWKS URL, required claims and claims forwarded as headers.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS jwt_config JSONB;
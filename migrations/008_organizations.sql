@@ -0,0 +1,62 @@
+-- Organizations own routes, API keys, cache rules and analytics. Members have
+-- one of four roles: owner, admin, editor or viewer.
+CREATE TABLE IF NOT EXISTS organizations (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(200) NOT NULL,
+    -- Set for the personal organization created for each user; personal
+    -- organizations cannot have other members.
+    personal_user_id VARCHAR(255) UNIQUE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS org_members (
+    org_id BIGINT NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    user_id VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'admin', 'editor', 'viewer')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (org_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_org_members_user_id ON org_members(user_id);
+
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_routes_org_id ON routes(org_id);
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_api_keys_org_id ON api_keys(org_id);
+
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS org_id BIGINT REFERENCES organizations(id) ON DELETE CASCADE;
+CREATE INDEX IF NOT EXISTS idx_cache_rules_org_id ON cache_rules(org_id);
+
+-- No foreign key: deleting an organization should not rewrite its history.
+ALTER TABLE analytics_events ADD COLUMN IF NOT EXISTS org_id BIGINT;
+CREATE INDEX IF NOT EXISTS idx_analytics_events_org_id ON analytics_events(org_id);
+
+-- Move every existing user into a personal organization that they own.
+INSERT INTO organizations (name, personal_user_id)
+SELECT user_id, user_id FROM (
+    SELECT user_id FROM routes
+    UNION SELECT unnest(shared_with) FROM routes
+    UNION SELECT user_id FROM api_keys
+    UNION SELECT user_id FROM cache_rules
+    UNION SELECT user_id FROM analytics_events
+) users
+WHERE user_id IS NOT NULL
+ON CONFLICT (personal_user_id) DO NOTHING;
+
+INSERT INTO org_members (org_id, user_id, role)
+SELECT id, personal_user_id, 'owner' FROM organizations WHERE personal_user_id IS NOT NULL
+ON CONFLICT (org_id, user_id) DO NOTHING;
+
+UPDATE routes t SET org_id = o.id FROM organizations o WHERE o.personal_user_id = t.user_id AND t.org_id IS NULL;
+UPDATE api_keys t SET org_id = o.id FROM organizations o WHERE o.personal_user_id = t.user_id AND t.org_id IS NULL;
+UPDATE cache_rules t SET org_id = o.id FROM organizations o WHERE o.personal_user_id = t.user_id AND t.org_id IS NULL;
+UPDATE analytics_events t SET org_id = o.id FROM organizations o WHERE o.personal_user_id = t.user_id AND t.org_id IS NULL;
+
+-- Routes are now shared with organizations rather than individual users.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS shared_with_org_ids BIGINT[] NOT NULL DEFAULT '{}';
+UPDATE routes r SET shared_with_org_ids = ARRAY(
+    SELECT o.id FROM organizations o WHERE o.personal_user_id = ANY(r.shared_with)
+)
+WHERE cardinality(r.shared_with) > 0;
+ALTER TABLE routes DROP COLUMN IF EXISTS shared_with;
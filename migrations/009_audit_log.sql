@@ -0,0 +1,33 @@
+-- Append-only record of admin mutations. Each organization's entries form a
+-- hash chain: hash covers the entry and the previous entry's hash, so editing
+-- or removing a row breaks every later hash.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    timestamp TIMESTAMP NOT NULL,
+    -- No foreign key: the log must outlive deleted organizations.
+    org_id BIGINT,
+    actor_user_id VARCHAR(255) NOT NULL,
+    action VARCHAR(100) NOT NULL,
+    resource_type VARCHAR(50) NOT NULL,
+    resource_id VARCHAR(255) NOT NULL DEFAULT '',
+    changes JSONB NOT NULL DEFAULT '{}',
+    request_id VARCHAR(100) NOT NULL DEFAULT '',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL UNIQUE
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_org_id ON audit_log(org_id, id DESC);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(org_id, actor_user_id);
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(org_id, resource_type, resource_id);
+
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+CREATE TRIGGER audit_log_append_only
+    BEFORE UPDATE OR DELETE ON audit_log
+    FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
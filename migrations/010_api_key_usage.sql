@@ -0,0 +1,25 @@
+-- Usage counters maintained from batched analytics events, so that unused
+-- keys can be found without scanning analytics_events.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMP;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS last_used_ip VARCHAR(45) NOT NULL DEFAULT '';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS request_count BIGINT NOT NULL DEFAULT 0;
+
+-- Backfill from the events recorded so far.
+UPDATE api_keys k
+SET request_count = usage.request_count,
+    last_used_at = usage.last_used_at,
+    last_used_ip = COALESCE(usage.last_used_ip, '')
+FROM (
+    SELECT DISTINCT ON (api_key_id)
+        api_key_id,
+        COUNT(*) OVER (PARTITION BY api_key_id) AS request_count,
+        timestamp AS last_used_at,
+        ip_address AS last_used_ip
+    FROM analytics_events
+    WHERE api_key_id IS NOT NULL
+    ORDER BY api_key_id, timestamp DESC
+) usage
+WHERE k.id = usage.api_key_id;
+
+-- Per-key usage time series.
+CREATE INDEX IF NOT EXISTS idx_analytics_events_api_key_timestamp ON analytics_events(api_key_id, timestamp);
@@ -0,0 +1,9 @@
+-- cache_key_pattern is now a list of key components (see README). Patterns
+-- were never applied before this change: every rule keyed on method, path
+-- and body, whatever its pattern said. Rules still holding the old '*'
+-- default, or a string the new grammar rejects, move to the equivalent
+-- pattern, which also includes the query string. Patterns already written
+-- in the new grammar are kept, so the migration can be run again.
+ALTER TABLE cache_rules ALTER COLUMN cache_key_pattern SET DEFAULT 'method path query body';
+UPDATE cache_rules SET cache_key_pattern = 'method path query body'
+WHERE cache_key_pattern !~* '^\s*(method|path|query|apikey|org|consumer|body|segment:[0-9]+|(query|header):\S*[^\s,]\S*)(\s+(method|path|query|apikey|org|consumer|body|segment:[0-9]+|(query|header):\S*[^\s,]\S*))*\s*$';
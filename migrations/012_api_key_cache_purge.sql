@@ -0,0 +1,3 @@
+-- Keys allowed to purge cached responses by tag through /_gateway/purge,
+-- typically held by the backends behind the organization's routes.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS cache_purge BOOLEAN NOT NULL DEFAULT false;
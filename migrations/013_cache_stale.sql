@@ -0,0 +1,8 @@
+-- How long past their freshness lifetime cached responses may still be
+-- served: while being refreshed in the background, or when the backend
+-- fails.
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS stale_while_revalidate_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS stale_if_error_seconds INTEGER NOT NULL DEFAULT 0;
+
+-- Stale serves are also counted as cache hits.
+ALTER TABLE analytics_events ADD COLUMN IF NOT EXISTS cache_stale BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+-- Cache tier ('local' or 'redis') that served a cache hit; NULL otherwise.
+ALTER TABLE analytics_events ADD COLUMN IF NOT EXISTS cache_tier VARCHAR(10);
@@ -0,0 +1,5 @@
+-- Methods a cache rule applies to (GET, HEAD, POST), and short-lived caching
+-- of error responses such as 404s.
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS methods TEXT[] NOT NULL DEFAULT '{GET}';
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS negative_ttl_seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS negative_status_codes INTEGER[] NOT NULL DEFAULT '{404}';
@@ -0,0 +1,3 @@
+-- Per-rule limit on the size of stored cache bodies; 0 uses the gateway's
+-- CACHE_MAX_ENTRY_BYTES.
+ALTER TABLE cache_rules ADD COLUMN IF NOT EXISTS max_entry_bytes BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,3 @@
+-- Per-route response compression policy: encodings, minimum size and the
+-- content types to compress. NULL disables compression.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS compression JSONB;
@@ -0,0 +1,5 @@
+-- Per-route request body limits: maximum size (0 = MAX_REQUEST_BODY_BYTES),
+-- allowed content types (empty = any) and JSON validation.
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS max_body_bytes BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS allowed_content_types TEXT[] NOT NULL DEFAULT '{}';
+ALTER TABLE routes ADD COLUMN IF NOT EXISTS validate_json BOOLEAN NOT NULL DEFAULT false;